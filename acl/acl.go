@@ -18,9 +18,13 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/odeke-em/acl/audit"
 	"github.com/odeke-em/acl/common"
 	"github.com/odeke-em/acl/permission"
 	"github.com/odeke-em/acl/scope"
@@ -31,43 +35,140 @@ const (
 	ruleSeparator       = "\n"
 	scopeDelimiter      = ":"
 	permissionDelimiter = "-"
+	denyPrefix          = "!"
+	roleDelimiter       = "="
+	expiryDelimiter     = "@"
+	membershipDelimiter = "+="
+	memberDelimiter     = ","
 )
 
 var (
 	ErrUserAlreadyExists = errors.New("user already exists")
 	ErrUserDoesnotExist  = errors.New("user does not exist")
 	ErrUninitializedACL  = errors.New("uninitialized ACL")
+	ErrNilAcl            = errors.New("nil Acl")
 )
 
-var emptyStruct = struct{}{}
-
-type rulesMap map[scope.Scope]map[permission.Permission]struct{}
+// rulesMap holds the effective permission bitmask granted to each scope.
+type rulesMap map[scope.Scope]permission.Permission
 
 type Acl struct {
-	rules rulesMap
-	ttl   int64
-	name  string
-	uuid  string
-	mu    sync.Mutex
+	auditor   audit.Auditor
+	rules     rulesMap
+	denies    rulesMap
+	expiries  expiryMap
+	roles     map[string]*role
+	assigned  map[scope.Scope]map[string]struct{}
+	members   memberMap
+	memberOf  memberMap
+	clock     func() time.Time
+	watchers  map[chan Change]struct{}
+	observers map[*observer]struct{}
+	holders   *holderIndex
+	seq       uint64
+	ttl       int64
+	name      string
+	uuid      string
+	snap      atomic.Pointer[snapshot]
+	mu        sync.Mutex
 }
 
+// New parses s like Stoa and gives the Acl a fresh UUID.
 func New(s string) (aclV *Acl, err error) {
-	return Stoa(s)
+	aclV, err = Stoa(s)
+	aclV.uuid = newUUID()
+	return
+}
+
+// Name returns the name of the Acl, which is empty unless set.
+func (a *Acl) Name() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.name
+}
+
+// SetName sets the name of the Acl.
+func (a *Acl) SetName(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.name = name
+}
+
+// UUID returns the UUID that New gave the Acl, if any.
+func (a *Acl) UUID() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.uuid
 }
 
 func newUUID() string {
 	return uuid.UUID4().String()
 }
 
+// Stoa parses the text form of an Acl, as described by Header.
+// Text without a header is read in the legacy format.
 func Stoa(s string) (aclV *Acl, err error) {
 	aclV = &Acl{
-		rules: make(rulesMap),
+		rules:    make(rulesMap),
+		denies:   make(rulesMap),
+		expiries: make(expiryMap),
+	}
+
+	definitions, items, err := splitRules(s)
+	if err != nil {
+		return
+	}
+
+	if defErr := aclV.parseRoleDefinitions(definitions); defErr != nil {
+		err = common.ReComposeError(err, defErr.Error())
+	}
+
+	for _, item := range items {
+		if indexUnescaped(item, membershipDelimiter) >= 0 {
+			if mErr := aclV.parseMembership(item); mErr != nil {
+				err = common.ReComposeError(err, mErr.Error())
+			}
+			continue
+		}
+
+		if rErr := aclV.parseRule(item); rErr != nil {
+			err = common.ReComposeError(err, rErr.Error())
+		}
+	}
+
+	return
+}
+
+// splitRules splits the text form s into its role definitions, which
+// are defined before anything else so that they can be referenced ahead
+// of their definition, and its other rules.
+func splitRules(s string) (definitions, items []string, err error) {
+	classify := func(item string) {
+		if isRoleDefinition(item) {
+			definitions = append(definitions, item)
+		} else {
+			items = append(items, item)
+		}
+	}
+
+	version, rules, err := parseHeader(strings.Split(s, ruleSeparator))
+	if err != nil {
+		return
 	}
 
-	rules := strings.Split(s, ruleSeparator)
 	for _, rule := range rules {
 		trimmed := strings.Trim(rule, " ")
-		if rule == "" {
+		if trimmed == "" {
+			continue
+		}
+
+		if version != legacyVersion {
+			if !strings.HasPrefix(trimmed, commentPrefix) {
+				classify(trimmed)
+			}
 			continue
 		}
 
@@ -77,88 +178,190 @@ func Stoa(s string) (aclV *Acl, err error) {
 			if scTrimmed == "" {
 				continue
 			}
-			scopePermissions := strings.Split(scTrimmed, permissionDelimiter)
-			count := len(scopePermissions)
-			if count < 1 {
-				continue
-			}
 
-			first, rest := scopePermissions[0], scopePermissions[1:]
+			classify(scTrimmed)
+		}
+	}
+
+	return
+}
+
+// parseRule parses a "scope-permissions-permissions" rule.
+func (a *Acl) parseRule(rule string) (err error) {
+	scopePermissions := splitUnescaped(rule, permissionDelimiter)
+	first, rest := unescapeName(scopePermissions[0]), scopePermissions[1:]
+
+	scFromS, scErr := scope.Atos(first)
+	if scErr != nil {
+		return fmt.Errorf("scopeStr: %q err: %s", first, scErr.Error())
+	}
 
-			scFromS, scErr := scope.Atos(first)
-			if scErr != nil {
-				err = common.ReComposeError(err, fmt.Sprintf("scopeStr: %q err: %s", first, scErr.Error()))
+	retr := a.rules[scFromS]
+	denied := a.denies[scFromS]
+
+	for _, permStr := range rest {
+		permTrimmed := strings.Trim(permStr, " ")
+		if permTrimmed == "" {
+			continue
+		}
+
+		ptr := &retr
+		if strings.HasPrefix(permTrimmed, denyPrefix) {
+			permStr = strings.TrimPrefix(permTrimmed, denyPrefix)
+			ptr = &denied
+		}
+
+		var until time.Time
+		if i := strings.LastIndex(permStr, expiryDelimiter); i >= 0 {
+			var untilErr error
+			permStr, until, untilErr = splitExpiry(permStr, i)
+			if untilErr == nil && ptr == &denied {
+				untilErr = errors.New("denials cannot expire")
+			}
+			if untilErr != nil {
+				err = common.ReComposeError(err, fmt.Sprintf("permStr: %q err: %s", permTrimmed, untilErr.Error()))
 				continue
 			}
+		}
 
-			retr, ok := aclV.rules[scFromS]
-			if !ok {
-				retr = make(map[permission.Permission]struct{})
+		if ptr == &retr && until.IsZero() {
+			var roles []string
+			permStr, roles = a.extractRoles(permStr)
+			for _, name := range roles {
+				a.assignRole(scFromS, name)
 			}
+		}
 
-			for _, permStr := range rest {
-				permTrimmed := strings.Trim(permStr, " ")
-				if permTrimmed == "" {
-					continue
-				}
-				perm, permErr := permission.Atop(permStr)
-				if permErr != nil {
-					err = common.ReComposeError(err, fmt.Sprintf("permStr: %q err: %s", permStr, permErr.Error()))
-					continue
-				}
-
-				retr[perm] = emptyStruct
-			}
+		perm, permErr := permission.Atop(permStr)
+		if permErr != nil {
+			err = common.ReComposeError(err, fmt.Sprintf("permStr: %q err: %s", permStr, permErr.Error()))
+			continue
+		}
 
-			aclV.rules[scFromS] = retr
+		if !until.IsZero() {
+			a.setExpiry(scFromS, perm, until)
+			continue
 		}
+
+		*ptr |= perm
+	}
+
+	a.rules[scFromS] = retr
+	if denied != permission.None {
+		a.denies[scFromS] = denied
 	}
 
 	return
 }
 
+func splitExpiry(permStr string, i int) (string, time.Time, error) {
+	secs, err := strconv.ParseInt(strings.Trim(permStr[i+len(expiryDelimiter):], " "), 10, 64)
+	if err != nil {
+		return permStr, time.Time{}, err
+	}
+
+	return permStr[:i], time.Unix(secs, 0), nil
+}
+
+// setExpiry records until as the expiry of every bit in perm,
+// regardless of whether that time has already passed.
+func (a *Acl) setExpiry(sc scope.Scope, perm permission.Permission, until time.Time) {
+	if a.expiries == nil {
+		a.expiries = make(expiryMap)
+	}
+
+	expiries, ok := a.expiries[sc]
+	if !ok {
+		expiries = make(map[permission.Permission]time.Time)
+		a.expiries[sc] = expiries
+	}
+
+	forEachBit(perm, func(bit permission.Permission) {
+		expiries[bit] = until
+	})
+}
+
+// String returns the rules as they were granted, in the text form
+// described by Header. Stoa(a.String()) is Equal to a.
 func (a *Acl) String() string {
+	return a.format(identity)
+}
+
+// Minimal returns the rules with every permission that is implied
+// by another granted permission left out.
+func (a *Acl) Minimal() string {
+	return a.format(permission.Minimal)
+}
+
+// Expanded returns the rules with every implied permission spelled out.
+func (a *Acl) Expanded() string {
+	return a.format(permission.Closure)
+}
+
+func identity(p permission.Permission) permission.Permission {
+	return p
+}
+
+func (a *Acl) format(transform func(permission.Permission) permission.Permission) string {
 	if a == nil {
 		return "[nil]"
 	}
 
-	remapped := make(map[string]string)
-
-	keys := []string{}
-	for sscope, permissionMap := range a.rules {
-		scopeStr := sscope.String()
-
-		permRemap := []string{}
-		for perm, _ := range permissionMap {
-			permRemap = append(permRemap, perm.String())
-		}
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-		sort.Sort(sort.StringSlice(permRemap))
+	return a.formatLocked(transform)
+}
 
-		remapped[scopeStr] = strings.Join(permRemap, permission.Separator)
-		keys = append(keys, scopeStr)
+func (a *Acl) formatLocked(transform func(permission.Permission) permission.Permission) string {
+	all := append([]string{Header}, a.formatRoles(transform)...)
+	for _, line := range a.formatScopes(transform) {
+		all = append(all, line.text)
 	}
+	all = append(all, a.formatMemberships()...)
 
-	sort.Sort(sort.StringSlice(keys))
+	return strings.Join(all, ruleSeparator)
+}
+
+// scopeLine is the text form of the rules of one scope.
+type scopeLine struct {
+	scope string
+	text  string
+}
 
-	all := []string{}
-	for _, key := range keys {
-		retr, _ := remapped[key]
+// formatScopes returns the text form of the rules of every scope, ordered by scope.
+func (a *Acl) formatScopes(transform func(permission.Permission) permission.Permission) []scopeLine {
+	lines := make([]scopeLine, 0, len(a.rules))
+	for sscope, perms := range a.rules {
+		scopeStr := sscope.String()
 
-		repr := key
-		if len(retr) >= 1 {
-			repr = fmt.Sprintf("%s%s%s", repr, permissionDelimiter, retr)
+		sects := []string{escapeName(scopeStr)}
+		if grant := a.formatGrant(transform(perms), a.assigned[sscope]); grant != "" {
+			sects = append(sects, grant)
+		}
+		sects = append(sects, a.formatExpiries(sscope, transform)...)
+		if denied := a.denies[sscope]; denied != permission.None {
+			sects = append(sects, denyPrefix+denied.String())
 		}
-		all = append(all, repr)
+
+		lines = append(lines, scopeLine{scope: scopeStr, text: strings.Join(sects, permissionDelimiter)})
 	}
 
-	return strings.Join(all, ruleSeparator)
+	sort.Slice(lines, func(i, j int) bool { return lines[i].scope < lines[j].scope })
+	return lines
 }
 
 func (a *Acl) Remove(userId string, permissions ...permission.Permission) (pass, fail []permission.Permission, err error) {
+	return a.remove("", userId, permissions)
+}
+
+func (a *Acl) remove(actor, userId string, permissions []permission.Permission) (pass, fail []permission.Permission, err error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	ev := event(actor, OpRemove, userId)
+	defer func() { a.emit(ev, err) }()
+
 	// TODO: Potential caching for userId lookups and conversions
 	sc, scErr := scope.New(userId)
 	if scErr != nil {
@@ -166,39 +369,70 @@ func (a *Acl) Remove(userId string, permissions ...permission.Permission) (pass,
 		return
 	}
 
-	permMap, ok := a.rules[sc]
+	current, ok := a.rules[sc]
 	if !ok {
 		err = fmt.Errorf("no such userId %q found", userId)
 		return
 	}
 
-	alreadyRemoved := map[permission.Permission]struct{}{}
+	pass, fail = a.removeLocked(ev, sc, current, permissions)
+	return
+}
+
+// removeLocked removes permissions from sc, which is granted current
+// permanently, filling in ev. Temporary grants that are still live can
+// be removed as well. It has to be called with a.mu held.
+func (a *Acl) removeLocked(ev *operation, sc scope.Scope, current permission.Permission, permissions []permission.Permission) (pass, fail []permission.Permission) {
+	held := a.held(sc)
+	left, pass, fail := removeBits(held, permissions)
+	removed := held &^ left
+	a.rules[sc] = current &^ removed
+	a.clearExpiries(sc, removed)
+	ev.Before, ev.After, ev.perms = held, left, removed
+	return
+}
+
+// removeBits clears permissions from current. A permission passes if
+// every one of its bits was set, either still or before an earlier
+// permission in the same call cleared it.
+func removeBits(current permission.Permission, permissions []permission.Permission) (left permission.Permission, pass, fail []permission.Permission) {
+	alreadyRemoved := permission.None
 	for _, perm := range permissions {
 		ptr := &fail
-		if _, ok := permMap[perm]; ok {
-			delete(permMap, perm)
-			alreadyRemoved[perm] = emptyStruct
-			ptr = &pass
-		} else if _, ok := alreadyRemoved[perm]; ok {
+		if perm&^(current|alreadyRemoved) == 0 {
+			alreadyRemoved |= perm & current
+			current &^= perm
 			ptr = &pass
 		}
 
 		*ptr = append(*ptr, perm)
 	}
 
-	return
+	return current, pass, fail
 }
 
-func (a *Acl) RegisterUser(userId string) (err error) {
+func (a *Acl) RegisterUser(userId string) error {
+	return a.registerUser("", userId)
+}
+
+func (a *Acl) registerUser(actor, userId string) (err error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	ev := event(actor, OpRegisterUser, userId)
+	defer func() { a.emit(ev, err) }()
+
 	sc, scErr := scope.New(userId)
 	if scErr != nil {
 		err = scErr
 		return
 	}
 
+	return a.registerLocked(sc)
+}
+
+// registerLocked registers sc. It has to be called with a.mu held.
+func (a *Acl) registerLocked(sc scope.Scope) error {
 	if a.rules == nil {
 		a.rules = make(rulesMap)
 	}
@@ -207,14 +441,21 @@ func (a *Acl) RegisterUser(userId string) (err error) {
 		return ErrUserAlreadyExists
 	}
 
-	a.rules[sc] = make(map[permission.Permission]struct{})
-	return
+	a.rules[sc] = permission.None
+	return nil
 }
 
 func (a *Acl) DeRegisterUser(userId string) error {
+	return a.deRegisterUser("", userId)
+}
+
+func (a *Acl) deRegisterUser(actor, userId string) (err error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	ev := event(actor, OpDeRegisterUser, userId)
+	defer func() { a.emit(ev, err) }()
+
 	if a.rules == nil {
 		return ErrUninitializedACL
 	}
@@ -228,65 +469,133 @@ func (a *Acl) DeRegisterUser(userId string) error {
 		return ErrUserDoesnotExist
 	}
 
-	delete(a.rules, sc)
+	a.deRegisterLocked(ev, sc)
 	return nil
 }
 
+// deRegisterLocked drops sc along with its grants, denials, roles and
+// memberships, filling in ev. It has to be called with a.mu held.
+func (a *Acl) deRegisterLocked(ev *operation, sc scope.Scope) {
+	ev.Before, ev.affected = a.held(sc), a.dependents([]scope.Scope{sc})
+	delete(a.rules, sc)
+	delete(a.denies, sc)
+	delete(a.expiries, sc)
+	delete(a.assigned, sc)
+	a.dropMemberships(sc)
+}
+
 func (a *Acl) Insert(userId string, permissions ...permission.Permission) (added []permission.Permission, err error) {
+	return a.insert("", userId, permissions, true)
+}
+
+// insert grants permissions to userId, only for the TTL if withTTL is set.
+func (a *Acl) insert(actor, userId string, permissions []permission.Permission, withTTL bool) (added []permission.Permission, err error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	ev := event(actor, OpInsert, userId)
+	defer func() { a.emit(ev, err) }()
+
 	sc, scErr := scope.New(userId)
 	if scErr != nil {
 		err = scErr
 		return
 	}
 
-	permMap, ok := a.rules[sc]
+	current, ok := a.rules[sc]
 	if !ok {
 		err = fmt.Errorf("no such userId %q found", userId)
 		return
 	}
 
-	for _, perm := range permissions {
-		if _, ok := permMap[perm]; !ok {
-			added = append(added, perm)
-			permMap[perm] = emptyStruct
-		}
+	if withTTL && a.ttl > 0 {
+		until := ceilSecond(a.currentTime().Add(time.Duration(a.ttl) * time.Second))
+		return a.insertUntil(ev, sc, until, permissions)
 	}
 
+	added = a.insertLocked(ev, sc, current, permissions)
 	return
 }
 
-func (a *Acl) Check(userId string, permissions ...permission.Permission) (wasSet, notSet []permission.Permission, err error) {
-	a.mu.Lock()
-	defer a.mu.Unlock()
+// insertLocked grants permissions to sc, which is granted current,
+// permanently, filling in ev. Temporary grants of the same bits become
+// permanent. It has to be called with a.mu held.
+func (a *Acl) insertLocked(ev *operation, sc scope.Scope, current permission.Permission, permissions []permission.Permission) (added []permission.Permission) {
+	ev.Before, ev.perms = a.held(sc), union(permissions)
+	a.rules[sc], added = insertBits(current, permissions)
+	a.clearExpiries(sc, a.rules[sc])
+	ev.After = a.held(sc)
+	return
+}
 
-	if a.rules == nil {
-		err = ErrUninitializedACL
-		return
+// insertBits sets permissions on current. Only the bits that
+// were not already set are reported as added.
+func insertBits(current permission.Permission, permissions []permission.Permission) (permission.Permission, []permission.Permission) {
+	var added []permission.Permission
+	for _, perm := range permissions {
+		if fresh := perm &^ current; fresh != permission.None {
+			added = append(added, fresh)
+			current |= fresh
+		}
 	}
 
-	sc, scErr := scope.New(userId)
-	if scErr != nil {
-		err = scErr
-		return
-	}
+	return current, added
+}
 
-	permMap, ok := a.rules[sc]
-	if !ok {
-		err = ErrUserDoesnotExist
-		return
+// Check reports which permissions userId holds. Explicitly denied
+// permissions are reported as notSet; use CheckDenied to tell them apart.
+func (a *Acl) Check(userId string, permissions ...permission.Permission) (wasSet, notSet []permission.Permission, err error) {
+	err = a.check("", userId, permissions, &wasSet, &notSet, &notSet)
+	return
+}
+
+// check sorts permissions into wasSet, denied and notSet, auditing
+// one decision per permission. It reads the current snapshot rather
+// than taking the lock, so checks run in parallel with each other.
+func (a *Acl) check(actor, userId string, permissions []permission.Permission, wasSet, denied, notSet *[]permission.Permission) (err error) {
+	return checkSnapshot(a.snapshot(), actor, userId, permissions, wasSet, denied, notSet)
+}
+
+// checkSnapshot makes the check that check describes against snap.
+func checkSnapshot(snap *snapshot, actor, userId string, permissions []permission.Permission, wasSet, denied, notSet *[]permission.Permission) (err error) {
+	r, err := snap.lookup(userId)
+	if err != nil {
+		snap.audit(event(actor, OpCheck, userId), err)
+		return err
 	}
 
+	// A permission is set only if all of its bits are set, either
+	// directly or through implication, and none of them is denied.
+	granted, deniedBits := r.at(snap.now())
 	for _, perm := range permissions {
-		ptr := &notSet
-		if _, ok := permMap[perm]; ok {
-			ptr = &wasSet
+		ptr, decision := notSet, NotGranted
+		if perm&deniedBits != permission.None {
+			ptr, decision = denied, Denied
+		} else if perm&granted == perm {
+			ptr, decision = wasSet, Granted
 		}
 
 		*ptr = append(*ptr, perm)
+
+		if snap.auditor != nil {
+			ev := event(actor, OpCheck, userId)
+			ev.Requested, ev.Decision = perm, decision.String()
+			snap.audit(ev, nil)
+		}
+	}
+
+	return nil
+}
+
+// effective returns the permissions granted to and denied from userId.
+func (a *Acl) effective(userId string) (granted, denied permission.Permission, err error) {
+	snap := a.snapshot()
+
+	r, err := snap.lookup(userId)
+	if err != nil {
+		return
 	}
 
+	granted, denied = r.at(snap.now())
 	return
 }
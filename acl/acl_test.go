@@ -277,7 +277,7 @@ func TestCheck(t *testing.T) {
 	perms := []permission.Permission{
 		permission.Delete, permission.Write,
 	}
-	notSetPerm := permission.Read
+	notSetPerm := permission.Execute
 
 	if err := acl.RegisterUser(uid); err != nil {
 		t.Errorf("%s expected success got %v", uid, err)
@@ -309,3 +309,130 @@ func TestCheck(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckCompositeGrants(t *testing.T) {
+	acl, err := Stoa("user-read|write:other-execute")
+	if err != nil {
+		t.Fatalf("expected a successful parse, got %v", err)
+	}
+
+	cases := []struct {
+		perm permission.Permission
+		want bool
+	}{
+		{perm: permission.Read, want: true},
+		{perm: permission.Write, want: true},
+		{perm: permission.Read | permission.Write, want: true},
+		{perm: permission.Execute, want: false},
+		{perm: permission.Read | permission.Execute, want: false},
+	}
+
+	for _, tc := range cases {
+		wasSet, _, err := acl.Check("user", tc.perm)
+		if err != nil {
+			t.Errorf("%q: unexpected err %v", tc.perm, err)
+		}
+
+		if got := len(wasSet) == 1; got != tc.want {
+			t.Errorf("%q: wanted %v got %v", tc.perm, tc.want, got)
+		}
+	}
+
+	if got, want := acl.String(), Header+"\nother-execute\nuser-read|write"; got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+}
+
+func TestInsertAndRemoveBits(t *testing.T) {
+	acl := Acl{}
+	uid := "bits"
+	if err := acl.RegisterUser(uid); err != nil {
+		t.Fatalf("registering %q: %v", uid, err)
+	}
+
+	if _, err := acl.Insert(uid, permission.Read|permission.Write); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	added, err := acl.Insert(uid, permission.Write|permission.Delete)
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if len(added) != 1 || added[0] != permission.Delete {
+		t.Errorf("expected only %q to be added, got %v", permission.Delete, added)
+	}
+
+	pass, fail, err := acl.Remove(uid, permission.Write, permission.Write|permission.Read, permission.Execute)
+	if err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if len(pass) != 2 || len(fail) != 1 || fail[0] != permission.Execute {
+		t.Errorf("pass: %v fail: %v", pass, fail)
+	}
+
+	wasSet, notSet, _ := acl.Check(uid, permission.Delete, permission.Execute)
+	if len(wasSet) != 1 || wasSet[0] != permission.Delete || len(notSet) != 1 {
+		t.Errorf("wasSet: %v notSet: %v", wasSet, notSet)
+	}
+}
+
+var approve = func() permission.Permission {
+	perm, err := permission.Register("approve")
+	if err != nil {
+		panic(err)
+	}
+	return perm
+}()
+
+func TestStoaRegisteredPermissions(t *testing.T) {
+	acl, err := Stoa("manager-approve|read")
+	if err != nil {
+		t.Fatalf("expected a successful parse, got %v", err)
+	}
+
+	wasSet, _, err := acl.Check("manager", approve)
+	if err != nil || len(wasSet) != 1 {
+		t.Errorf("expected %q to be set, got %v err %v", approve, wasSet, err)
+	}
+
+	if got, want := acl.String(), Header+"\nmanager-approve|read"; got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+}
+
+func TestCheckImpliedPermissions(t *testing.T) {
+	acl, err := Stoa("editor-write:admin-delete|read|execute")
+	if err != nil {
+		t.Fatalf("expected a successful parse, got %v", err)
+	}
+
+	cases := []struct {
+		user string
+		perm permission.Permission
+		want bool
+	}{
+		{user: "editor", perm: permission.Read, want: true},
+		{user: "editor", perm: permission.List, want: true},
+		{user: "editor", perm: permission.Delete, want: false},
+		{user: "admin", perm: permission.Write | permission.List, want: true},
+	}
+
+	for _, tc := range cases {
+		wasSet, _, err := acl.Check(tc.user, tc.perm)
+		if err != nil {
+			t.Errorf("%s %q: unexpected err %v", tc.user, tc.perm, err)
+		}
+
+		if got := len(wasSet) == 1; got != tc.want {
+			t.Errorf("%s %q: wanted %v got %v", tc.user, tc.perm, tc.want, got)
+		}
+	}
+
+	if got, want := acl.Minimal(), Header+"\nadmin-execute|delete\neditor-write"; got != want {
+		t.Errorf("minimal: wanted %q got %q", want, got)
+	}
+
+	if got, want := acl.Expanded(), Header+"\nadmin-list|read|write|execute|delete\neditor-list|read|write"; got != want {
+		t.Errorf("expanded: wanted %q got %q", want, got)
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"time"
+
+	"github.com/odeke-em/acl/audit"
+	"github.com/odeke-em/acl/permission"
+	"github.com/odeke-em/acl/scope"
+)
+
+// Op identifies an operation on an Acl.
+type Op int
+
+const (
+	OpRegisterUser Op = iota
+	OpDeRegisterUser
+	OpInsert
+	OpRemove
+	OpDeny
+	OpUndeny
+	OpSweep
+	OpAddMember
+	OpRemoveMember
+	OpDefineRole
+	OpUndefineRole
+	OpAssignRole
+	OpUnassignRole
+	OpCheck
+	// OpReplace swaps out every rule at once, as the Unmarshal
+	// methods do. It carries no details, so watchers have to
+	// resync through Versioned.
+	OpReplace
+	OpSetTTL
+)
+
+var opNames = map[Op]string{
+	OpRegisterUser:   "registerUser",
+	OpDeRegisterUser: "deRegisterUser",
+	OpInsert:         "insert",
+	OpRemove:         "remove",
+	OpDeny:           "deny",
+	OpUndeny:         "undeny",
+	OpSweep:          "sweep",
+	OpAddMember:      "addMember",
+	OpRemoveMember:   "removeMember",
+	OpDefineRole:     "defineRole",
+	OpUndefineRole:   "undefineRole",
+	OpAssignRole:     "assignRole",
+	OpUnassignRole:   "unassignRole",
+	OpCheck:          "check",
+	OpReplace:        "replace",
+	OpSetTTL:         "setTTL",
+}
+
+func (op Op) String() string {
+	if name, ok := opNames[op]; ok {
+		return name
+	}
+	return UnknownOpStr
+}
+
+const UnknownOpStr = "unknown"
+
+// SetAuditor makes a report every mutation and decision to auditor.
+// A nil auditor turns auditing off.
+func (a *Acl) SetAuditor(auditor audit.Auditor) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.setAuditorLocked(auditor)
+}
+
+// setAuditorLocked has to be called with a.mu held.
+func (a *Acl) setAuditorLocked(auditor audit.Auditor) {
+	a.auditor = auditor
+	a.reconfigure()
+}
+
+// Session performs operations on an Acl on behalf of an actor,
+// who is recorded in every audit event that they cause.
+type Session struct {
+	acl   *Acl
+	actor string
+}
+
+// As returns a Session acting on a on behalf of actor.
+func (a *Acl) As(actor string) *Session {
+	return &Session{acl: a, actor: actor}
+}
+
+func (s *Session) RegisterUser(userId string) error {
+	return s.acl.registerUser(s.actor, userId)
+}
+
+func (s *Session) DeRegisterUser(userId string) error {
+	return s.acl.deRegisterUser(s.actor, userId)
+}
+
+func (s *Session) Insert(userId string, permissions ...permission.Permission) ([]permission.Permission, error) {
+	return s.acl.insert(s.actor, userId, permissions, true)
+}
+
+func (s *Session) InsertUntil(userId string, until time.Time, permissions ...permission.Permission) ([]permission.Permission, error) {
+	return s.acl.insertUntilFor(s.actor, userId, until, permissions)
+}
+
+func (s *Session) Remove(userId string, permissions ...permission.Permission) (pass, fail []permission.Permission, err error) {
+	return s.acl.remove(s.actor, userId, permissions)
+}
+
+func (s *Session) Deny(userId string, permissions ...permission.Permission) ([]permission.Permission, error) {
+	return s.acl.deny(s.actor, userId, permissions)
+}
+
+func (s *Session) Undeny(userId string, permissions ...permission.Permission) (pass, fail []permission.Permission, err error) {
+	return s.acl.undeny(s.actor, userId, permissions)
+}
+
+func (s *Session) AddMember(group, userId string) error {
+	return s.acl.addMemberAs(s.actor, group, userId)
+}
+
+func (s *Session) RemoveMember(group, userId string) error {
+	return s.acl.removeMember(s.actor, group, userId)
+}
+
+func (s *Session) DefineRole(name string, perms permission.Permission, includes ...string) error {
+	return s.acl.defineRoleAs(s.actor, name, perms, includes)
+}
+
+func (s *Session) UndefineRole(name string) error {
+	return s.acl.undefineRole(s.actor, name)
+}
+
+func (s *Session) AssignRole(userId, name string) error {
+	return s.acl.assignRoleAs(s.actor, userId, name)
+}
+
+func (s *Session) UnassignRole(userId, name string) error {
+	return s.acl.unassignRole(s.actor, userId, name)
+}
+
+func (s *Session) Sweep() int {
+	return s.acl.sweep(s.actor)
+}
+
+func (s *Session) Check(userId string, permissions ...permission.Permission) (wasSet, notSet []permission.Permission, err error) {
+	err = s.acl.check(s.actor, userId, permissions, &wasSet, &notSet, &notSet)
+	return
+}
+
+func (s *Session) CheckDenied(userId string, permissions ...permission.Permission) (wasSet, denied, notSet []permission.Permission, err error) {
+	err = s.acl.check(s.actor, userId, permissions, &wasSet, &denied, &notSet)
+	return
+}
+
+// operation is an audit event along with what it takes to replay it.
+type operation struct {
+	audit.Event
+	op       Op
+	perms    permission.Permission
+	includes []string
+	ttl      time.Duration
+	// affected overrides the scopes that the snapshot is advanced for,
+	// for mutations that lose track of them.
+	affected []scope.Scope
+}
+
+// event starts the audit event for op by actor on scopeStr.
+func event(actor string, op Op, scopeStr string) *operation {
+	return &operation{
+		Event: audit.Event{Op: op.String(), Actor: actor, Scope: scopeStr},
+		op:    op,
+	}
+}
+
+// emit publishes the snapshot that ev leaves behind and reports ev.
+// It has to be called with a.mu held.
+func (a *Acl) emit(ev *operation, err error) {
+	if ev.op != OpCheck {
+		// Even a failed mutation may have changed something.
+		a.advance(a.affected(ev))
+	}
+	a.report(ev, err)
+}
+
+// report completes ev with the outcome err, reports it to the auditor
+// and, for successful mutations, to watchers. The snapshot has to have
+// been advanced past ev already. It has to be called with a.mu held.
+func (a *Acl) report(ev *operation, err error) {
+	ev.Time = a.currentTime()
+	if err != nil {
+		ev.Err = err.Error()
+	}
+
+	if a.auditor != nil {
+		a.auditor.Audit(ev.Event)
+	}
+
+	if ev.op == OpCheck {
+		return
+	}
+
+	a.reindex(ev.Scope)
+	m := ev.mutation()
+	a.notify(&m)
+	if err == nil {
+		a.publish(m)
+	}
+}
+
+func (ev *operation) mutation() Mutation {
+	return Mutation{
+		Op:          ev.op,
+		Scope:       ev.Scope,
+		Subject:     ev.Subject,
+		Permissions: ev.perms,
+		Includes:    ev.includes,
+		Expiry:      ev.Expiry,
+		TTL:         ev.ttl,
+	}
+}
+
+func union(permissions []permission.Permission) (all permission.Permission) {
+	for _, perm := range permissions {
+		all |= perm
+	}
+	return
+}
+
+// held returns the permissions recorded on sc that are currently live.
+func (a *Acl) held(sc scope.Scope) permission.Permission {
+	return a.rules[sc] | a.temporary(sc, a.currentTime())
+}
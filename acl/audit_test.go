@@ -0,0 +1,108 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/odeke-em/acl/audit"
+	"github.com/odeke-em/acl/permission"
+)
+
+func TestAuditEvents(t *testing.T) {
+	fc := newFakeClock()
+
+	var events []audit.Event
+	acl := Acl{}
+	acl.SetClock(fc.Now)
+	acl.SetAuditor(audit.AuditorFunc(func(ev audit.Event) {
+		events = append(events, ev)
+	}))
+
+	admin := acl.As("admin")
+	if err := admin.RegisterUser("dave"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := admin.Insert("dave", permission.Read|permission.Write); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if _, _, err := acl.Remove("dave", permission.Write); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, _, err := admin.Check("dave", permission.Read, permission.Write); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if err := admin.DeRegisterUser("dave"); err != nil {
+		t.Fatalf("deregister: %v", err)
+	}
+	if _, err := admin.Insert("dave", permission.Read); err == nil {
+		t.Fatalf("expected an error inserting for a deregistered user")
+	}
+
+	want := []audit.Event{
+		{Op: "registerUser", Actor: "admin", Scope: "dave"},
+		{Op: "insert", Actor: "admin", Scope: "dave", After: permission.Read | permission.Write},
+		{Op: "remove", Scope: "dave", Before: permission.Read | permission.Write, After: permission.Read},
+		{Op: "check", Actor: "admin", Scope: "dave", Requested: permission.Read, Decision: "granted"},
+		{Op: "check", Actor: "admin", Scope: "dave", Requested: permission.Write, Decision: "not granted"},
+		{Op: "deRegisterUser", Actor: "admin", Scope: "dave", Before: permission.Read},
+	}
+
+	if len(events) != len(want)+1 {
+		t.Fatalf("expected %d events, got %d: %+v", len(want)+1, len(events), events)
+	}
+
+	for i, w := range want {
+		got := events[i]
+		if !got.Time.Equal(fc.Now()) {
+			t.Errorf("#%d: expected the time of the injected clock, got %v", i, got.Time)
+		}
+		got.Time = w.Time
+		if got != w {
+			t.Errorf("#%d: wanted %+v got %+v", i, w, got)
+		}
+	}
+
+	if last := events[len(events)-1]; last.Op != "insert" || last.Err == "" {
+		t.Errorf("expected a failed insert to be audited, got %+v", last)
+	}
+}
+
+func TestAuditChainCoversMutations(t *testing.T) {
+	var buf bytes.Buffer
+	chain := audit.NewChain(&buf)
+
+	acl := Acl{}
+	acl.SetAuditor(audit.Mutations(chain))
+
+	acl.RegisterUser("erin")
+	acl.Insert("erin", permission.Read)
+	acl.Check("erin", permission.Read)
+	acl.Remove("erin", permission.Read)
+	acl.DeRegisterUser("erin")
+
+	last, err := audit.Verify(&buf)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if last == nil || last.Seq != 3 {
+		t.Fatalf("expected 4 chained mutations, got %+v", last)
+	}
+	if !strings.Contains(string(last.Event), "deRegisterUser") {
+		t.Errorf("expected the last record to be the deregistration, got %s", last.Event)
+	}
+}
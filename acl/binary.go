@@ -0,0 +1,473 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/odeke-em/acl/permission"
+	"github.com/odeke-em/acl/scope"
+)
+
+// The binary form of an Acl is, with integers varint encoded, strings
+// written as their length followed by their bytes and names, which are
+// scopes and roles, written through a string table as described below:
+//
+//	magic     "ACLB"
+//	version   1
+//	metadata  name, uuid, ttl in seconds
+//	roles     count, then name, permissions, included count and names,
+//	          with included roles ahead of the roles including them
+//	scopes    count, then scope, grant, deny, temporary count with
+//	          permissions and expiry in Unix seconds, role count and names
+//	groups    count, then group, member count and members
+//	crc       CRC-32C of everything before it, 4 bytes little endian
+//
+// The string table is built up as the records are written so that they
+// can be written out as soon as they are encoded. The first time that a
+// name appears it is written as 0 followed by the string, which gives it
+// the next index in the table, starting at 0. After that it is written
+// as its index plus one.
+const (
+	binaryMagic   = "ACLB"
+	binaryVersion = 1
+
+	// maxBinaryString bounds the strings read, so that a corrupt
+	// length cannot make ReadFrom allocate without limit.
+	maxBinaryString = 1 << 20
+)
+
+var (
+	ErrInvalidEncoding = errors.New("invalid binary encoding")
+	ErrChecksum        = errors.New("checksum mismatch")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// MarshalBinary returns the binary form of a.
+func (a *Acl) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := a.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces the rules, name, UUID and TTL of a with
+// those decoded from data, which must hold nothing else.
+func (a *Acl) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if _, err := a.ReadFrom(r); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrInvalidEncoding, r.Len())
+	}
+
+	return nil
+}
+
+// WriteTo streams the binary form of a to w, writing each record as
+// soon as it is encoded rather than building the whole encoding first.
+// The Acl is locked against mutation until WriteTo returns.
+func (a *Acl) WriteTo(w io.Writer) (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := &binaryWriter{w: bufio.NewWriter(cw), crc: crc32.New(castagnoli)}
+
+	a.encode(bw)
+
+	if bw.err == nil {
+		var sum [4]byte
+		binary.LittleEndian.PutUint32(sum[:], bw.crc.Sum32())
+		_, bw.err = bw.w.Write(sum[:])
+	}
+	if bw.err == nil {
+		bw.err = bw.w.Flush()
+	}
+
+	return cw.n, bw.err
+}
+
+// ReadFrom replaces the rules, name, UUID and TTL of a with those
+// decoded from r, leaving a alone if decoding fails. It stops at the
+// end of the encoding if r is an io.ByteReader, such as a bufio.Reader,
+// and may otherwise read past it.
+func (a *Acl) ReadFrom(r io.Reader) (int64, error) {
+	byteReader, ok := r.(binaryByteReader)
+	if !ok {
+		byteReader = bufio.NewReader(r)
+	}
+
+	br := &binaryReader{r: byteReader, crc: crc32.New(castagnoli)}
+	decoded := br.decode()
+
+	if br.err == nil {
+		want := br.crc.Sum32()
+		var sum [4]byte
+		br.read(sum[:])
+		if br.err == nil && binary.LittleEndian.Uint32(sum[:]) != want {
+			br.err = ErrChecksum
+		}
+	}
+	if br.err != nil {
+		return br.n, br.err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.replaceRules(decoded)
+	a.name, a.uuid, a.ttl = decoded.name, decoded.uuid, decoded.ttl
+	return br.n, nil
+}
+
+func (a *Acl) encode(bw *binaryWriter) {
+	bw.write([]byte(binaryMagic))
+	bw.uvarint(binaryVersion)
+
+	bw.string(a.name)
+	bw.string(a.uuid)
+	bw.varint(a.ttl)
+
+	names := func(set map[string]struct{}) {
+		sorted := sortedNames(set)
+		bw.uvarint(uint64(len(sorted)))
+		for _, name := range sorted {
+			bw.name(name)
+		}
+	}
+
+	bw.uvarint(uint64(len(a.roles)))
+	written := make(map[string]bool, len(a.roles))
+	var writeRole func(name string)
+	writeRole = func(name string) {
+		if written[name] {
+			return
+		}
+		written[name] = true
+
+		r := a.roles[name]
+		for _, included := range sortedNames(r.includes) {
+			writeRole(included)
+		}
+		bw.name(name)
+		bw.uvarint(uint64(r.perms))
+		names(r.includes)
+	}
+	for _, name := range sortedKeys(a.roles) {
+		writeRole(name)
+	}
+
+	scopes := make([]scope.Scope, 0, len(a.rules))
+	for sc := range a.rules {
+		scopes = append(scopes, sc)
+	}
+	sort.Slice(scopes, func(i, j int) bool { return scopes[i].String() < scopes[j].String() })
+
+	bw.uvarint(uint64(len(scopes)))
+	for _, sc := range scopes {
+		bw.name(sc.String())
+		bw.uvarint(uint64(a.rules[sc]))
+		bw.uvarint(uint64(a.denies[sc]))
+
+		temporary := a.temporaryGrants(sc)
+		bw.uvarint(uint64(len(temporary)))
+		for _, t := range temporary {
+			bw.uvarint(uint64(t.Permissions))
+			bw.varint(t.Until.Unix())
+		}
+
+		names(a.assigned[sc])
+	}
+
+	groups := make([]scope.Scope, 0, len(a.members))
+	for gsc, set := range a.members {
+		if len(set) > 0 {
+			groups = append(groups, gsc)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].String() < groups[j].String() })
+
+	bw.uvarint(uint64(len(groups)))
+	for _, gsc := range groups {
+		bw.name(gsc.String())
+
+		members := make([]string, 0, len(a.members[gsc]))
+		for msc := range a.members[gsc] {
+			members = append(members, msc.String())
+		}
+		sort.Strings(members)
+		bw.uvarint(uint64(len(members)))
+		for _, member := range members {
+			bw.name(member)
+		}
+	}
+}
+
+func (br *binaryReader) decode() *Acl {
+	magic := make([]byte, len(binaryMagic))
+	if br.read(magic); br.err == nil && string(magic) != binaryMagic {
+		br.fail(fmt.Errorf("%w: bad magic %q", ErrInvalidEncoding, magic))
+	}
+	version := br.uvarint()
+	if br.err == nil && version != binaryVersion {
+		br.fail(fmt.Errorf("%w: %d", ErrUnsupportedVersion, version))
+	}
+
+	b := &Acl{
+		rules:    make(rulesMap),
+		denies:   make(rulesMap),
+		expiries: make(expiryMap),
+	}
+	b.name = br.string()
+	b.uuid = br.string()
+	b.ttl = br.varint()
+
+	lookupScope := func() scope.Scope {
+		s := br.name()
+		if br.err != nil {
+			return scope.UnknownScope
+		}
+		sc, err := scope.Atos(s)
+		if err != nil {
+			br.fail(fmt.Errorf("%w: %v", ErrInvalidEncoding, err))
+		}
+		return sc
+	}
+	names := func() []string {
+		list := []string{}
+		for i, count := uint64(0), br.uvarint(); i < count && br.err == nil; i++ {
+			list = append(list, br.name())
+		}
+		return list
+	}
+
+	for i, count := uint64(0), br.uvarint(); i < count && br.err == nil; i++ {
+		name := br.name()
+		perms := permission.Permission(br.uvarint())
+		includes := names()
+		if br.err == nil {
+			br.fail(b.defineRole(name, perms, includes))
+		}
+	}
+
+	for i, count := uint64(0), br.uvarint(); i < count && br.err == nil; i++ {
+		sc := lookupScope()
+		b.rules[sc] |= permission.Permission(br.uvarint())
+		if deny := permission.Permission(br.uvarint()); deny != permission.None {
+			b.denies[sc] |= deny
+		}
+
+		for j, temporary := uint64(0), br.uvarint(); j < temporary && br.err == nil; j++ {
+			perms := permission.Permission(br.uvarint())
+			until := br.varint()
+			b.setExpiry(sc, perms, time.Unix(until, 0))
+		}
+
+		for _, name := range names() {
+			if _, ok := b.roles[name]; !ok && br.err == nil {
+				br.fail(fmt.Errorf("%w: %q", ErrRoleDoesnotExist, name))
+			}
+			b.assignRole(sc, name)
+		}
+	}
+
+	for i, count := uint64(0), br.uvarint(); i < count && br.err == nil; i++ {
+		gsc := lookupScope()
+		b.ensureRegistered(gsc)
+		for j, members := uint64(0), br.uvarint(); j < members && br.err == nil; j++ {
+			msc := lookupScope()
+			if br.err != nil {
+				break
+			}
+			b.ensureRegistered(msc)
+			if err := b.addMember(gsc, msc); !errors.Is(err, ErrAlreadyMember) {
+				br.fail(err)
+			}
+		}
+	}
+
+	return b
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// binaryWriter writes varints, strings and names, keeping a running
+// CRC of what it wrote, the string table of the names written so far
+// and the first error that it hit.
+type binaryWriter struct {
+	w       *bufio.Writer
+	crc     hash.Hash32
+	table   map[string]uint64
+	err     error
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (bw *binaryWriter) write(p []byte) {
+	if bw.err != nil {
+		return
+	}
+	bw.crc.Write(p)
+	_, bw.err = bw.w.Write(p)
+}
+
+func (bw *binaryWriter) uvarint(v uint64) {
+	bw.write(bw.scratch[:binary.PutUvarint(bw.scratch[:], v)])
+}
+
+func (bw *binaryWriter) varint(v int64) {
+	bw.write(bw.scratch[:binary.PutVarint(bw.scratch[:], v)])
+}
+
+func (bw *binaryWriter) string(s string) {
+	bw.uvarint(uint64(len(s)))
+	bw.write([]byte(s))
+}
+
+// name writes s through the string table, adding it if it is new.
+func (bw *binaryWriter) name(s string) {
+	if i, ok := bw.table[s]; ok {
+		bw.uvarint(i + 1)
+		return
+	}
+
+	if bw.table == nil {
+		bw.table = make(map[string]uint64)
+	}
+	bw.table[s] = uint64(len(bw.table))
+	bw.uvarint(0)
+	bw.string(s)
+}
+
+type binaryByteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// binaryReader reads varints, strings and names, keeping a running
+// CRC of what it read, a count of the bytes read, the string table of
+// the names read so far and the first error that it hit, after which
+// every read returns the zero value.
+type binaryReader struct {
+	r     binaryByteReader
+	crc   hash.Hash32
+	n     int64
+	table []string
+	err   error
+}
+
+func (br *binaryReader) fail(err error) {
+	if br.err != nil || err == nil {
+		return
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	br.err = err
+}
+
+func (br *binaryReader) ReadByte() (byte, error) {
+	c, err := br.r.ReadByte()
+	if err == nil {
+		br.n++
+		br.crc.Write([]byte{c})
+	}
+	return c, err
+}
+
+func (br *binaryReader) read(p []byte) {
+	if br.err != nil {
+		return
+	}
+	n, err := io.ReadFull(br.r, p)
+	br.n += int64(n)
+	br.crc.Write(p[:n])
+	br.fail(err)
+}
+
+func (br *binaryReader) uvarint() uint64 {
+	if br.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(br)
+	br.fail(err)
+	return v
+}
+
+func (br *binaryReader) varint() int64 {
+	if br.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(br)
+	br.fail(err)
+	return v
+}
+
+func (br *binaryReader) string() string {
+	size := br.uvarint()
+	if br.err == nil && size > maxBinaryString {
+		br.fail(fmt.Errorf("%w: string of %d bytes", ErrInvalidEncoding, size))
+	}
+	if br.err != nil {
+		return ""
+	}
+
+	p := make([]byte, size)
+	br.read(p)
+	return string(p)
+}
+
+// name reads a name written through the string table.
+func (br *binaryReader) name() string {
+	ref := br.uvarint()
+	if br.err == nil && ref > uint64(len(br.table)) {
+		br.fail(fmt.Errorf("%w: string index %d out of %d", ErrInvalidEncoding, ref-1, len(br.table)))
+	}
+	if br.err != nil {
+		return ""
+	}
+
+	if ref > 0 {
+		return br.table[ref-1]
+	}
+
+	s := br.string()
+	if br.err == nil {
+		br.table = append(br.table, s)
+	}
+	return s
+}
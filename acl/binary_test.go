@@ -0,0 +1,255 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"testing"
+	"testing/quick"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+func TestBinaryRoundTrips(t *testing.T) {
+	roundTrips := func(q quickAcl) bool {
+		q.SetName("quick")
+		data, err := q.MarshalBinary()
+		if err != nil {
+			return false
+		}
+
+		var decoded Acl
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Logf("%q: %v", q.String(), err)
+			return false
+		}
+		return decoded.Equal(q.Acl) && decoded.Name() == "quick"
+	}
+
+	if err := quick.Check(roundTrips, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBinaryStreams(t *testing.T) {
+	first, _ := New("viewer=read\nalice-viewer-!delete\nstaff+=alice")
+	second, _ := New("bob-write|execute")
+
+	var buf bytes.Buffer
+	for _, a := range []*Acl{first, second} {
+		n, err := a.WriteTo(&buf)
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if data, _ := a.MarshalBinary(); int(n) != len(data) {
+			t.Errorf("wrote %d bytes, expected %d", n, len(data))
+		}
+	}
+
+	total := int64(buf.Len())
+	r := bufio.NewReader(&buf)
+	read := int64(0)
+	for _, want := range []*Acl{first, second} {
+		var got Acl
+		n, err := got.ReadFrom(r)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		read += n
+
+		if !got.Equal(want) || got.UUID() != want.UUID() {
+			t.Errorf("wanted %q got %q", want, &got)
+		}
+	}
+
+	if read != total {
+		t.Errorf("read %d bytes of %d", read, total)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("expected every byte to be consumed, got %v", err)
+	}
+}
+
+// chunkWriter records the size of the largest write made to it.
+type chunkWriter struct {
+	writes, largest int
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	cw.writes++
+	cw.largest = max(cw.largest, len(p))
+	return len(p), nil
+}
+
+func TestWriteToStreams(t *testing.T) {
+	a := largeAcl(10000)
+	data, _ := a.MarshalBinary()
+
+	var cw chunkWriter
+	if _, err := a.WriteTo(&cw); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if cw.writes < 2 || cw.largest > 4096 {
+		t.Errorf("expected %d bytes in small writes, got %d writes of up to %d bytes", len(data), cw.writes, cw.largest)
+	}
+}
+
+func TestBinaryWritesNamesOnce(t *testing.T) {
+	a, _ := Stoa("viewer=read\nalice-write-viewer\nbob-viewer\nstaff+=alice,bob")
+	data, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	for _, name := range []string{"alice", "bob", "staff", "viewer"} {
+		if got := bytes.Count(data, []byte(name)); got != 1 {
+			t.Errorf("expected %q to be written once, got %d", name, got)
+		}
+	}
+}
+
+func TestBinaryRejectsBadStringIndex(t *testing.T) {
+	var buf bytes.Buffer
+	bw := &binaryWriter{w: bufio.NewWriter(&buf), crc: crc32.New(castagnoli)}
+	bw.write([]byte(binaryMagic))
+	bw.uvarint(binaryVersion)
+	bw.string("")
+	bw.string("")
+	bw.varint(0)
+	// No roles, then one scope that refers to a name never written.
+	bw.uvarint(0)
+	bw.uvarint(1)
+	bw.uvarint(1)
+	bw.w.Flush()
+
+	if err := new(Acl).UnmarshalBinary(buf.Bytes()); !errors.Is(err, ErrInvalidEncoding) {
+		t.Errorf("expected ErrInvalidEncoding, got %v", err)
+	}
+}
+
+func TestReadFromPublishesReplacement(t *testing.T) {
+	source, _ := Stoa("bob-write")
+	data, _ := source.MarshalBinary()
+
+	a, _ := Stoa("alice-read")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := a.Watch(ctx)
+
+	if _, err := a.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if change := <-changes; change.Op != OpReplace || change.Seq != 1 {
+		t.Errorf("wanted a replace at 1, got %v at %d", change.Op, change.Seq)
+	}
+}
+
+func TestBinaryRejectsCorruption(t *testing.T) {
+	a, _ := Stoa("viewer=read\nalice-viewer-!delete\nbob-write\nstaff+=alice,bob")
+	data, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	flipped := append([]byte{}, data...)
+	flipped[len(flipped)/2] ^= 0x01
+
+	badMagic := append([]byte{}, data...)
+	badMagic[0] = 'X'
+
+	cases := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{name: "flipped", data: flipped},
+		{name: "bad magic", data: badMagic, want: ErrInvalidEncoding},
+		{name: "truncated", data: data[:len(data)-1], want: io.ErrUnexpectedEOF},
+		{name: "trailing", data: append(append([]byte{}, data...), 0), want: ErrInvalidEncoding},
+		{name: "empty", data: nil, want: io.ErrUnexpectedEOF},
+	}
+
+	for _, tc := range cases {
+		acl, _ := Stoa("carol-read")
+		err := acl.UnmarshalBinary(tc.data)
+		if err == nil || (tc.want != nil && !errors.Is(err, tc.want)) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+		if tc.name != "trailing" && acl.String() != Header+"\ncarol-read" {
+			t.Errorf("%s: expected the acl to be left alone, got %q", tc.name, acl)
+		}
+	}
+
+	flipped = append([]byte{}, data...)
+	flipped[len(flipped)-1] ^= 0x01
+	if err := new(Acl).UnmarshalBinary(flipped); !errors.Is(err, ErrChecksum) {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+}
+
+func largeAcl(users int) *Acl {
+	a := &Acl{}
+	a.DefineRole("viewer", permission.Read)
+	for i := 0; i < users; i++ {
+		uid := fmt.Sprintf("org:team%d:user%d", i%100, i)
+		a.RegisterUser(uid)
+		a.Insert(uid, permission.Write|permission.Execute)
+		if i%3 == 0 {
+			a.AssignRole(uid, "viewer")
+		}
+	}
+	return a
+}
+
+func TestBinaryIsCompact(t *testing.T) {
+	a := largeAcl(10000)
+
+	data, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if text := a.String(); len(data) >= len(text) {
+		t.Errorf("expected the binary form to be smaller, got %d bytes against %d", len(data), len(text))
+	}
+}
+
+func BenchmarkMarshalBinary(b *testing.B) {
+	a := largeAcl(10000)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := a.WriteTo(io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalBinary(b *testing.B) {
+	data, _ := largeAcl(10000).MarshalBinary()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var a Acl
+		if err := a.UnmarshalBinary(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
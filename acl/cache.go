@@ -0,0 +1,333 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/odeke-em/acl/permission"
+	"github.com/odeke-em/acl/scope"
+)
+
+// Cache remembers the decisions of a Store, evicting the least recently
+// used ones beyond its size. Every change to the Store evicts exactly
+// the decisions it could affect, and decisions that rest on temporary
+// grants lapse when the first of those grants expires, as told by the
+// clock of the Acl holding it. It is safe for concurrent use.
+//
+// A Cache only sits in front of Store.Authorize. To cache the checks of
+// a single Acl, put it in a Store of its own.
+type Cache struct {
+	store *Store
+	size  int
+	stop  func()
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[cacheKey]*list.Element
+	index   map[cacheDep]map[*cacheEntry]struct{}
+	// version changes with every invalidation, so that a decision
+	// made across one is not cached.
+	version uint64
+	stats   CacheStats
+}
+
+// CacheStats counts what a Cache has done since it was made.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	// Evictions counts the decisions dropped to stay within size.
+	Evictions uint64
+	// Invalidations counts the decisions dropped because
+	// a change could have affected them.
+	Invalidations uint64
+	// Len is the number of decisions currently held.
+	Len int
+}
+
+type cacheKey struct {
+	principal string
+	resource  string
+	mask      permission.Permission
+}
+
+type depKind int
+
+const (
+	// depResource is the Acl of a resource as a whole,
+	// including whether it exists and whether it inherits.
+	depResource depKind = iota
+	depScope
+	depRole
+)
+
+// cacheDep is something in the Store that a decision was read from.
+type cacheDep struct {
+	resource string
+	kind     depKind
+	name     string
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	allowed bool
+	deps    []cacheDep
+	// deadlines holds, for each Acl with temporary grants involved,
+	// when the first of them lapses.
+	deadlines []deadline
+}
+
+// deadline is a time as told by the clock of an Acl.
+type deadline struct {
+	until time.Time
+	clock func() time.Time
+}
+
+func (d deadline) passed() bool {
+	now := time.Now
+	if d.clock != nil {
+		now = d.clock
+	}
+
+	return !now().Before(d.until)
+}
+
+// live reports whether entry is still in force.
+func (entry *cacheEntry) live() bool {
+	for _, d := range entry.deadlines {
+		if d.passed() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NewCache returns a Cache of at most size decisions in front of store.
+// Close it once it is no longer used.
+func NewCache(store *Store, size int) *Cache {
+	c := &Cache{
+		store:   store,
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[cacheKey]*list.Element),
+		index:   make(map[cacheDep]map[*cacheEntry]struct{}),
+	}
+	c.stop = store.observe(c.invalidate)
+	return c
+}
+
+// Close stops c from following changes to its Store and empties it.
+func (c *Cache) Close() {
+	c.stop()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	clear(c.entries)
+	clear(c.index)
+	c.version++
+}
+
+// Stats returns the counters of c.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Len = len(c.entries)
+	return stats
+}
+
+// Authorize is Store.Authorize for a single mask, answered from c when
+// possible. Errors are passed on and never cached.
+func (c *Cache) Authorize(principal, resource string, mask permission.Permission) (bool, error) {
+	key := cacheKey{principal: principal, resource: resource, mask: mask}
+
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if entry.live() {
+			c.stats.Hits++
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			return entry.allowed, nil
+		}
+		c.remove(elem)
+	}
+	c.stats.Misses++
+	version := c.version
+	c.mu.Unlock()
+
+	allowed, err := c.store.Authorize(principal, resource, mask)
+	if err != nil {
+		return false, err
+	}
+	entry := &cacheEntry{key: key, allowed: allowed}
+	c.dependencies(entry)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.version == version {
+		c.insert(entry)
+	}
+	return allowed, nil
+}
+
+// dependencies fills in what the decision in entry was read from.
+func (c *Cache) dependencies(entry *cacheEntry) {
+	sc, _ := scope.New(entry.key.principal)
+
+	resources, acls := c.store.ancestry(entry.key.resource)
+	for i, aclV := range acls {
+		resource := resources[i]
+		entry.deps = append(entry.deps, cacheDep{resource: resource, kind: depResource})
+		if aclV == nil {
+			continue
+		}
+
+		scopes, roles, expires, clock := aclV.dependencies(sc)
+		for _, principal := range scopes {
+			entry.deps = append(entry.deps, cacheDep{resource: resource, kind: depScope, name: principal.String()})
+		}
+		for _, name := range roles {
+			entry.deps = append(entry.deps, cacheDep{resource: resource, kind: depRole, name: name})
+		}
+		if !expires.IsZero() {
+			entry.deadlines = append(entry.deadlines, deadline{until: expires, clock: clock})
+		}
+	}
+}
+
+// insert has to be called with c.mu held.
+func (c *Cache) insert(entry *cacheEntry) {
+	if c.size <= 0 {
+		return
+	}
+
+	if elem, ok := c.entries[entry.key]; ok {
+		c.remove(elem)
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	for _, dep := range entry.deps {
+		dependents, ok := c.index[dep]
+		if !ok {
+			dependents = make(map[*cacheEntry]struct{})
+			c.index[dep] = dependents
+		}
+		dependents[entry] = emptyStruct
+	}
+
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove has to be called with c.mu held.
+func (c *Cache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+
+	for _, dep := range entry.deps {
+		dependents := c.index[dep]
+		delete(dependents, entry)
+		if len(dependents) == 0 {
+			delete(c.index, dep)
+		}
+	}
+}
+
+// invalidate evicts the decisions that m, made to the Acl of resource,
+// could affect. It runs with that Acl locked, so it must not call back
+// into the Store.
+func (c *Cache) invalidate(resource string, m *Mutation) {
+	dep := cacheDep{resource: resource, kind: depResource}
+	switch {
+	case m != nil && m.Op == OpSetTTL:
+		// The TTL only applies to later grants.
+		return
+	case m == nil, m.Op == OpReplace:
+	case m.Op == OpDefineRole || m.Op == OpUndefineRole:
+		dep.kind, dep.name = depRole, m.Subject
+	case m.Op == OpAddMember || m.Op == OpRemoveMember:
+		if sc, err := scope.New(m.Subject); err == nil {
+			dep.kind, dep.name = depScope, sc.String()
+		}
+	default:
+		if sc, err := scope.New(m.Scope); err == nil {
+			dep.kind, dep.name = depScope, sc.String()
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.version++
+	for entry := range c.index[dep] {
+		c.remove(c.entries[entry.key])
+		c.stats.Invalidations++
+	}
+}
+
+// dependencies returns what a decision for sc reads from a: the scopes of
+// sc and of the groups it belongs to, the roles they hold, including those
+// included by other roles, and when the first of their temporary grants
+// that are still in force expires, or zero if there is none, along with
+// the clock that tells it.
+func (a *Acl) dependencies(sc scope.Scope) (scopes []scope.Scope, roles []string, expires time.Time, clock func() time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	clock = a.clock
+	now := a.currentTime()
+	seen := make(map[string]struct{})
+
+	scopes = a.principals(sc)
+	for _, principal := range scopes {
+		for name := range a.assigned[principal] {
+			if _, ok := seen[name]; !ok {
+				seen[name] = emptyStruct
+				roles = append(roles, name)
+			}
+		}
+
+		for _, until := range a.expiries[principal] {
+			if until.After(now) && (expires.IsZero() || until.Before(expires)) {
+				expires = until
+			}
+		}
+	}
+
+	for i := 0; i < len(roles); i++ {
+		r, ok := a.roles[roles[i]]
+		if !ok {
+			continue
+		}
+		for name := range r.includes {
+			if _, ok := seen[name]; !ok {
+				seen[name] = emptyStruct
+				roles = append(roles, name)
+			}
+		}
+	}
+
+	return
+}
@@ -0,0 +1,375 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+func mustAuthorize(t *testing.T, c *Cache, principal, resource string, mask permission.Permission) bool {
+	t.Helper()
+
+	allowed, err := c.Authorize(principal, resource, mask)
+	if err != nil {
+		t.Fatalf("authorize %s on %s: %v", principal, resource, err)
+	}
+	return allowed
+}
+
+func TestCacheHitsAndMisses(t *testing.T) {
+	store := NewStore()
+	doc := store.Acl("doc-1")
+	if err := doc.RegisterUser("alice"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := doc.Insert("alice", permission.Write); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	c := NewCache(store, 16)
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		if !mustAuthorize(t, c, "alice", "doc-1", permission.Write) {
+			t.Errorf("#%d: expected alice to write doc-1", i)
+		}
+	}
+	if mustAuthorize(t, c, "alice", "doc-1", permission.Delete) {
+		t.Errorf("alice should not delete doc-1")
+	}
+
+	want := CacheStats{Hits: 2, Misses: 2, Len: 2}
+	if got := c.Stats(); got != want {
+		t.Errorf("stats: wanted %+v got %+v", want, got)
+	}
+
+	if _, err := c.Authorize(" ", "doc-1", permission.Read); err == nil {
+		t.Errorf("expected an error for an invalid principal")
+	}
+	if got := c.Stats().Len; got != 2 {
+		t.Errorf("errors should not be cached, got %d entries", got)
+	}
+}
+
+func TestCachePreciseInvalidation(t *testing.T) {
+	store := NewStore()
+	doc := store.Acl("doc-1")
+	for _, user := range []string{"alice", "bob", "carol"} {
+		if err := doc.RegisterUser(user); err != nil {
+			t.Fatalf("register %s: %v", user, err)
+		}
+	}
+	if err := doc.RegisterUser("staff"); err != nil {
+		t.Fatalf("register staff: %v", err)
+	}
+	if err := doc.AddMember("staff", "bob"); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	if err := doc.DefineRole("editor", permission.Write); err != nil {
+		t.Fatalf("define: %v", err)
+	}
+	if err := doc.DefineRole("auditor", permission.Read); err != nil {
+		t.Fatalf("define: %v", err)
+	}
+	if err := doc.AssignRole("carol", "editor"); err != nil {
+		t.Fatalf("assign: %v", err)
+	}
+
+	c := NewCache(store, 16)
+	defer c.Close()
+
+	warm := func() {
+		for _, user := range []string{"alice", "bob", "carol"} {
+			mustAuthorize(t, c, user, "doc-1", permission.Write)
+		}
+	}
+
+	cases := []struct {
+		desc    string
+		mutate  func() error
+		evicted int
+	}{
+		{
+			desc: "grant to alice",
+			mutate: func() error {
+				_, err := doc.Insert("alice", permission.Write)
+				return err
+			},
+			evicted: 1,
+		},
+		{
+			desc: "deny the group of bob",
+			mutate: func() error {
+				_, err := doc.Deny("staff", permission.Delete)
+				return err
+			},
+			evicted: 1,
+		},
+		{
+			desc:    "redefine the role of carol",
+			mutate:  func() error { return doc.DefineRole("editor", permission.Delete) },
+			evicted: 1,
+		},
+		{
+			desc:    "redefine a role nobody holds",
+			mutate:  func() error { return doc.DefineRole("auditor", permission.Execute) },
+			evicted: 0,
+		},
+		{
+			desc:    "fail to assign an undefined role",
+			mutate:  func() error { return doc.AssignRole("alice", "nobody") },
+			evicted: 1,
+		},
+		{
+			desc:    "register someone new",
+			mutate:  func() error { return doc.RegisterUser("dave") },
+			evicted: 0,
+		},
+	}
+
+	for _, tc := range cases {
+		warm()
+		before := c.Stats()
+		tc.mutate()
+		after := c.Stats()
+
+		if got := int(after.Invalidations - before.Invalidations); got != tc.evicted {
+			t.Errorf("%s: wanted %d invalidations got %d", tc.desc, tc.evicted, got)
+		}
+		if after.Len != before.Len-tc.evicted {
+			t.Errorf("%s: wanted %d entries got %d", tc.desc, before.Len-tc.evicted, after.Len)
+		}
+	}
+
+	if !mustAuthorize(t, c, "carol", "doc-1", permission.Delete) {
+		t.Errorf("carol should delete doc-1 through the redefined role")
+	}
+	if mustAuthorize(t, c, "bob", "doc-1", permission.Delete) {
+		t.Errorf("bob should be denied delete through staff")
+	}
+}
+
+func TestCacheInheritance(t *testing.T) {
+	store := NewStore()
+	projects := store.Acl("/projects")
+	if err := projects.RegisterUser("alice"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := projects.Insert("alice", permission.Write); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	store.Acl("/projects/a")
+
+	c := NewCache(store, 16)
+	defer c.Close()
+
+	resource := "/projects/a/doc"
+	steps := []struct {
+		desc   string
+		mutate func()
+		want   bool
+	}{
+		{desc: "inherited", mutate: func() {}, want: true},
+		{desc: "blocked", mutate: func() { store.BlockInheritance("/projects/a") }, want: false},
+		{desc: "allowed", mutate: func() { store.AllowInheritance("/projects/a") }, want: true},
+		{
+			desc: "denied in between",
+			mutate: func() {
+				between := &Acl{}
+				if err := between.RegisterUser("alice"); err != nil {
+					t.Fatalf("register: %v", err)
+				}
+				if _, err := between.Deny("alice", permission.Write); err != nil {
+					t.Fatalf("deny: %v", err)
+				}
+				store.Set("/projects/a", between)
+			},
+			want: false,
+		},
+		{desc: "deleted in between", mutate: func() { store.Delete("/projects/a") }, want: true},
+		{
+			desc: "revoked at the root",
+			mutate: func() {
+				if _, _, err := projects.Remove("alice", permission.Write); err != nil {
+					t.Fatalf("remove: %v", err)
+				}
+			},
+			want: false,
+		},
+	}
+
+	for _, step := range steps {
+		step.mutate()
+		for i := 0; i < 2; i++ {
+			if got := mustAuthorize(t, c, "alice", resource, permission.Write); got != step.want {
+				t.Errorf("%s #%d: wanted %v got %v", step.desc, i, step.want, got)
+			}
+		}
+	}
+}
+
+func TestCacheAncestorCreatedLater(t *testing.T) {
+	store := NewStore()
+	c := NewCache(store, 16)
+	defer c.Close()
+
+	if mustAuthorize(t, c, "alice", "/a/b", permission.Read) {
+		t.Fatalf("alice should not read /a/b yet")
+	}
+
+	parent := store.Acl("/a")
+	if err := parent.RegisterUser("alice"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := parent.Insert("alice", permission.Read); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	if !mustAuthorize(t, c, "alice", "/a/b", permission.Read) {
+		t.Errorf("expected the grant on the new ancestor to be seen")
+	}
+}
+
+func TestCacheDetachesReplacedAcls(t *testing.T) {
+	store := NewStore()
+	old := store.Acl("doc-1")
+	if err := old.RegisterUser("alice"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	store.Set("doc-1", &Acl{})
+
+	c := NewCache(store, 16)
+	defer c.Close()
+
+	mustAuthorize(t, c, "alice", "doc-1", permission.Read)
+	if _, err := old.Insert("alice", permission.Read); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if got := c.Stats().Invalidations; got != 0 {
+		t.Errorf("changes to a replaced Acl should not evict anything, got %d", got)
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	fc := newFakeClock()
+
+	store := NewStore()
+	doc := store.Acl("doc-1")
+	doc.SetClock(fc.Now)
+	if err := doc.RegisterUser("alice"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := doc.InsertFor("alice", time.Hour, permission.Write); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	c := NewCache(store, 16)
+	defer c.Close()
+
+	if !mustAuthorize(t, c, "alice", "doc-1", permission.Write) {
+		t.Errorf("expected the temporary grant to hold")
+	}
+	fc.Advance(59 * time.Minute)
+	if !mustAuthorize(t, c, "alice", "doc-1", permission.Write) {
+		t.Errorf("expected the temporary grant to still hold")
+	}
+	fc.Advance(time.Minute)
+	if mustAuthorize(t, c, "alice", "doc-1", permission.Write) {
+		t.Errorf("expected the temporary grant to have expired")
+	}
+
+	want := CacheStats{Hits: 1, Misses: 2, Len: 1}
+	if got := c.Stats(); got != want {
+		t.Errorf("stats: wanted %+v got %+v", want, got)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	store := NewStore()
+	c := NewCache(store, 2)
+	defer c.Close()
+
+	mustAuthorize(t, c, "alice", "doc-1", permission.Read)
+	mustAuthorize(t, c, "alice", "doc-2", permission.Read)
+	mustAuthorize(t, c, "alice", "doc-1", permission.Read)
+	mustAuthorize(t, c, "alice", "doc-3", permission.Read)
+
+	want := CacheStats{Hits: 1, Misses: 3, Evictions: 1, Len: 2}
+	if got := c.Stats(); got != want {
+		t.Errorf("stats: wanted %+v got %+v", want, got)
+	}
+
+	// doc-2 was the least recently used.
+	mustAuthorize(t, c, "alice", "doc-1", permission.Read)
+	mustAuthorize(t, c, "alice", "doc-2", permission.Read)
+	if got := c.Stats(); got.Hits != 2 || got.Misses != 4 {
+		t.Errorf("expected doc-1 to hit and doc-2 to miss, got %+v", got)
+	}
+}
+
+func TestCacheConcurrentUse(t *testing.T) {
+	store := NewStore()
+	doc := store.Acl("doc-1")
+	for i := 0; i < 4; i++ {
+		if err := doc.RegisterUser(fmt.Sprintf("user-%d", i)); err != nil {
+			t.Fatalf("register: %v", err)
+		}
+	}
+
+	c := NewCache(store, 8)
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		user := fmt.Sprintf("user-%d", i)
+
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := c.Authorize(user, "doc-1", permission.Write); err != nil {
+					t.Errorf("authorize: %v", err)
+				}
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := doc.Insert(user, permission.Write); err != nil {
+					t.Errorf("insert: %v", err)
+				}
+				if _, _, err := doc.Remove(user, permission.Write); err != nil {
+					t.Errorf("remove: %v", err)
+				}
+			}
+			if _, err := doc.Insert(user, permission.Write); err != nil {
+				t.Errorf("insert: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 4; i++ {
+		if !mustAuthorize(t, c, fmt.Sprintf("user-%d", i), "doc-1", permission.Write) {
+			t.Errorf("user-%d: the cache missed the final grant", i)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"fmt"
+
+	"github.com/odeke-em/acl/permission"
+	"github.com/odeke-em/acl/scope"
+)
+
+// Deny records explicit denials for userId. A denied bit always takes
+// precedence over a grant of the same bit, however that grant was
+// obtained, including through groups or implication. A denial recorded
+// on a group applies to all of its members, including nested ones.
+// Denials are not implied: denying read does not deny write. In text
+// form a denial is a permission segment prefixed with "!", e.g.
+// "contractor-read-!delete".
+func (a *Acl) Deny(userId string, permissions ...permission.Permission) (added []permission.Permission, err error) {
+	return a.deny("", userId, permissions)
+}
+
+func (a *Acl) deny(actor, userId string, permissions []permission.Permission) (added []permission.Permission, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ev := event(actor, OpDeny, userId)
+	defer func() { a.emit(ev, err) }()
+
+	sc, scErr := scope.New(userId)
+	if scErr != nil {
+		err = scErr
+		return
+	}
+
+	if _, ok := a.rules[sc]; !ok {
+		err = fmt.Errorf("no such userId %q found", userId)
+		return
+	}
+
+	return a.denyLocked(ev, sc, permissions), nil
+}
+
+// denyLocked denies permissions to sc, filling in ev.
+// It has to be called with a.mu held.
+func (a *Acl) denyLocked(ev *operation, sc scope.Scope, permissions []permission.Permission) (added []permission.Permission) {
+	if a.denies == nil {
+		a.denies = make(rulesMap)
+	}
+
+	ev.Before, ev.perms = a.denies[sc], union(permissions)
+	a.denies[sc], added = insertBits(a.denies[sc], permissions)
+	ev.After = a.denies[sc]
+	return
+}
+
+// Undeny lifts denials previously recorded by Deny.
+func (a *Acl) Undeny(userId string, permissions ...permission.Permission) (pass, fail []permission.Permission, err error) {
+	return a.undeny("", userId, permissions)
+}
+
+func (a *Acl) undeny(actor, userId string, permissions []permission.Permission) (pass, fail []permission.Permission, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ev := event(actor, OpUndeny, userId)
+	defer func() { a.emit(ev, err) }()
+
+	sc, scErr := scope.New(userId)
+	if scErr != nil {
+		err = scErr
+		return
+	}
+
+	if _, ok := a.rules[sc]; !ok {
+		err = fmt.Errorf("no such userId %q found", userId)
+		return
+	}
+
+	pass, fail = a.undenyLocked(ev, sc, permissions)
+	return
+}
+
+// undenyLocked lifts denials of permissions from sc, filling in ev.
+// It has to be called with a.mu held.
+func (a *Acl) undenyLocked(ev *operation, sc scope.Scope, permissions []permission.Permission) (pass, fail []permission.Permission) {
+	ev.Before = a.denies[sc]
+	left, pass, fail := removeBits(a.denies[sc], permissions)
+	ev.After, ev.perms = left, ev.Before&^left
+	if left == permission.None {
+		delete(a.denies, sc)
+	} else {
+		a.denies[sc] = left
+	}
+
+	return
+}
+
+// CheckDenied is like Check but reports explicitly denied
+// permissions separately from those that were merely not granted.
+// A permission with any denied bit is reported as denied.
+func (a *Acl) CheckDenied(userId string, permissions ...permission.Permission) (wasSet, denied, notSet []permission.Permission, err error) {
+	err = a.check("", userId, permissions, &wasSet, &denied, &notSet)
+	return
+}
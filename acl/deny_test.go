@@ -0,0 +1,87 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"testing"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+func TestDenyOverridesGrants(t *testing.T) {
+	acl, err := Stoa("contractor-delete-!write")
+	if err != nil {
+		t.Fatalf("expected a successful parse, got %v", err)
+	}
+
+	wasSet, denied, notSet, err := acl.CheckDenied("contractor",
+		permission.Delete, permission.Write, permission.Read|permission.Write, permission.Execute)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+
+	if len(wasSet) != 1 || wasSet[0] != permission.Delete {
+		t.Errorf("wasSet: %v", wasSet)
+	}
+	if len(denied) != 2 {
+		t.Errorf("denied: %v", denied)
+	}
+	if len(notSet) != 1 || notSet[0] != permission.Execute {
+		t.Errorf("notSet: %v", notSet)
+	}
+
+	wasSet, notSet, _ = acl.Check("contractor", permission.Write, permission.Read)
+	if len(wasSet) != 1 || len(notSet) != 1 || notSet[0] != permission.Write {
+		t.Errorf("wasSet: %v notSet: %v", wasSet, notSet)
+	}
+
+	if got, want := acl.String(), Header+"\ncontractor-delete-!write"; got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+}
+
+func TestDenyAndUndeny(t *testing.T) {
+	acl := Acl{}
+	uid := "temp"
+
+	if _, err := acl.Deny(uid, permission.Read); err == nil {
+		t.Errorf("did not pre-register user %q", uid)
+	}
+
+	if err := acl.RegisterUser(uid); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := acl.Insert(uid, permission.Read); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	added, err := acl.Deny(uid, permission.Read, permission.Read)
+	if err != nil || len(added) != 1 {
+		t.Errorf("deny: added %v err %v", added, err)
+	}
+
+	if wasSet, _, _ := acl.Check(uid, permission.Read); len(wasSet) != 0 {
+		t.Errorf("expected read to be denied")
+	}
+
+	pass, fail, err := acl.Undeny(uid, permission.Read, permission.Write)
+	if err != nil || len(pass) != 1 || len(fail) != 1 {
+		t.Errorf("undeny: pass %v fail %v err %v", pass, fail, err)
+	}
+
+	if wasSet, _, _ := acl.Check(uid, permission.Read); len(wasSet) != 1 {
+		t.Errorf("expected read to be granted once undenied")
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/odeke-em/acl/permission"
+	"github.com/odeke-em/acl/scope"
+)
+
+var (
+	ErrConflict = errors.New("patch conflict")
+	ErrNilPatch = errors.New("nil Patch")
+)
+
+type DiffKind int
+
+const (
+	ScopeModified DiffKind = iota
+	ScopeAdded
+	ScopeRemoved
+)
+
+// ScopeDiff is how the permanent grants and the denials of one scope
+// differ between two Acls. From and To are the grants before and after,
+// DeniedFrom and DeniedTo the denials.
+type ScopeDiff struct {
+	Scope      string
+	Kind       DiffKind
+	From       permission.Permission
+	To         permission.Permission
+	DeniedFrom permission.Permission
+	DeniedTo   permission.Permission
+}
+
+// Granted returns the bits that the diff grants.
+func (d ScopeDiff) Granted() permission.Permission {
+	return d.To &^ d.From
+}
+
+// Revoked returns the bits that the diff revokes.
+func (d ScopeDiff) Revoked() permission.Permission {
+	return d.From &^ d.To
+}
+
+// Patch is the changeset that turns one Acl into another, as far as
+// registered scopes, permanent grants and denials go. Temporary grants,
+// roles and memberships are not part of it.
+type Patch struct {
+	// FromName and ToName label the two sides in String.
+	FromName string
+	ToName   string
+	// Scopes holds one diff per changed scope, ordered by scope.
+	Scopes []ScopeDiff
+}
+
+// Diff returns the Patch that turns a into b, neither of which may be nil.
+func Diff(a, b *Acl) (*Patch, error) {
+	if a == nil || b == nil {
+		return nil, ErrNilAcl
+	}
+
+	from, to := a.policy(), b.policy()
+	p := &Patch{FromName: labelOr(a.Name(), "a"), ToName: labelOr(b.Name(), "b")}
+
+	seen := make(map[string]struct{}, len(from.rules)+len(to.rules))
+	for sc := range from.rules {
+		seen[sc] = emptyStruct
+	}
+	for sc := range to.rules {
+		seen[sc] = emptyStruct
+	}
+
+	for _, sc := range sortedNames(seen) {
+		before, inFrom := from.rules[sc]
+		after, inTo := to.rules[sc]
+		d := ScopeDiff{
+			Scope:      sc,
+			From:       before,
+			To:         after,
+			DeniedFrom: from.denies[sc],
+			DeniedTo:   to.denies[sc],
+		}
+
+		switch {
+		case !inFrom:
+			d.Kind = ScopeAdded
+		case !inTo:
+			d.Kind = ScopeRemoved
+		case d.From == d.To && d.DeniedFrom == d.DeniedTo:
+			continue
+		}
+		p.Scopes = append(p.Scopes, d)
+	}
+
+	return p, nil
+}
+
+func labelOr(label, fallback string) string {
+	if label == "" {
+		return fallback
+	}
+	return label
+}
+
+// Empty reports whether p changes nothing.
+func (p *Patch) Empty() bool {
+	return len(p.Scopes) == 0
+}
+
+// String renders p as a unified diff of the text form of each changed
+// scope, a removed line followed by an added one.
+func (p *Patch) String() string {
+	lines := []string{"--- " + p.FromName, "+++ " + p.ToName}
+	for _, d := range p.Scopes {
+		if d.Kind != ScopeAdded {
+			lines = append(lines, "-"+formatScopeLine(d.Scope, d.From, d.DeniedFrom))
+		}
+		if d.Kind != ScopeRemoved {
+			lines = append(lines, "+"+formatScopeLine(d.Scope, d.To, d.DeniedTo))
+		}
+	}
+
+	return strings.Join(lines, ruleSeparator)
+}
+
+func formatScopeLine(sc string, granted, denied permission.Permission) string {
+	sects := []string{escapeName(sc)}
+	if granted != permission.None {
+		sects = append(sects, granted.String())
+	}
+	if denied != permission.None {
+		sects = append(sects, denyPrefix+denied.String())
+	}
+
+	return strings.Join(sects, permissionDelimiter)
+}
+
+// Apply applies p to a all at once. It fails with ErrConflict, leaving
+// a alone, if any scope of a no longer matches the side of p that it
+// was diffed from. Checks see either none of p or all of it. Every
+// change is audited and published to watchers once p is applied.
+func (a *Acl) Apply(p *Patch) error {
+	if p == nil {
+		return ErrNilPatch
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	scopes := make([]scope.Scope, len(p.Scopes))
+	for i, d := range p.Scopes {
+		sc, scErr := scope.New(d.Scope)
+		if scErr != nil {
+			return scErr
+		}
+		scopes[i] = sc
+
+		current, ok := a.rules[sc]
+		switch {
+		case d.Kind == ScopeAdded && ok:
+			return fmt.Errorf("%w: %q was added already", ErrConflict, d.Scope)
+		case d.Kind != ScopeAdded && !ok:
+			return fmt.Errorf("%w: %q is not registered", ErrConflict, d.Scope)
+		case d.Kind != ScopeAdded && (current != d.From || a.denies[sc] != d.DeniedFrom):
+			return fmt.Errorf("%w: %q is %s, expected %s", ErrConflict, d.Scope,
+				formatScopeLine(d.Scope, current, a.denies[sc]), formatScopeLine(d.Scope, d.From, d.DeniedFrom))
+		}
+	}
+
+	var evs []*operation
+	for i, d := range p.Scopes {
+		evs = append(evs, a.applyScopeDiff(scopes[i], d)...)
+	}
+
+	var affected []scope.Scope
+	seen := make(map[scope.Scope]struct{})
+	for _, ev := range evs {
+		for _, sc := range a.affected(ev) {
+			if _, ok := seen[sc]; !ok {
+				seen[sc] = emptyStruct
+				affected = append(affected, sc)
+			}
+		}
+	}
+
+	a.advance(affected)
+	for _, ev := range evs {
+		a.report(ev, nil)
+	}
+	return nil
+}
+
+// applyScopeDiff makes d to sc, which has been checked against it,
+// through the same helpers as the methods that it stands in for. It
+// returns the events of those methods, which are left to the caller
+// to emit.
+func (a *Acl) applyScopeDiff(sc scope.Scope, d ScopeDiff) (evs []*operation) {
+	if d.Kind == ScopeRemoved {
+		ev := event("", OpDeRegisterUser, d.Scope)
+		a.deRegisterLocked(ev, sc)
+		return []*operation{ev}
+	}
+
+	if d.Kind == ScopeAdded {
+		// sc was checked to be unregistered, so this cannot fail.
+		a.registerLocked(sc)
+		evs = append(evs, event("", OpRegisterUser, d.Scope))
+	}
+
+	step := func(op Op, perms permission.Permission, fn func(ev *operation, permissions []permission.Permission)) {
+		if perms == permission.None {
+			return
+		}
+
+		ev := event("", op, d.Scope)
+		fn(ev, []permission.Permission{perms})
+		evs = append(evs, ev)
+	}
+
+	step(OpRemove, d.Revoked(), func(ev *operation, permissions []permission.Permission) {
+		a.removeLocked(ev, sc, a.rules[sc], permissions)
+	})
+	step(OpInsert, d.Granted(), func(ev *operation, permissions []permission.Permission) {
+		a.insertLocked(ev, sc, a.rules[sc], permissions)
+	})
+	step(OpUndeny, d.DeniedFrom&^d.DeniedTo, func(ev *operation, permissions []permission.Permission) {
+		a.undenyLocked(ev, sc, permissions)
+	})
+	step(OpDeny, d.DeniedTo&^d.DeniedFrom, func(ev *operation, permissions []permission.Permission) {
+		a.denyLocked(ev, sc, permissions)
+	})
+
+	return evs
+}
@@ -0,0 +1,206 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/odeke-em/acl/audit"
+	"github.com/odeke-em/acl/permission"
+)
+
+func mustDiff(t *testing.T, a, b *Acl) *Patch {
+	t.Helper()
+
+	p, err := Diff(a, b)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	return p
+}
+
+func TestDiff(t *testing.T) {
+	before, _ := Stoa("alice-read|write\nbob-execute\ncarol-read-!delete\nx\\-ray-list")
+	after, _ := Stoa("alice-read|execute\ncarol-read\ndave-write-!execute\nx\\-ray-list")
+	before.SetName("main")
+
+	patch := mustDiff(t, before, after)
+
+	want := []ScopeDiff{
+		{Scope: "alice", Kind: ScopeModified, From: permission.Read | permission.Write, To: permission.Read | permission.Execute},
+		{Scope: "bob", Kind: ScopeRemoved, From: permission.Execute},
+		{Scope: "carol", Kind: ScopeModified, From: permission.Read, To: permission.Read, DeniedFrom: permission.Delete},
+		{Scope: "dave", Kind: ScopeAdded, To: permission.Write, DeniedTo: permission.Execute},
+	}
+	if len(patch.Scopes) != len(want) {
+		t.Fatalf("wanted %d scopes got %+v", len(want), patch.Scopes)
+	}
+	for i, d := range patch.Scopes {
+		if d != want[i] {
+			t.Errorf("#%d: wanted %+v got %+v", i, want[i], d)
+		}
+	}
+
+	if got := patch.Scopes[0].Granted(); got != permission.Execute {
+		t.Errorf("expected execute to be granted, got %v", got)
+	}
+	if got := patch.Scopes[0].Revoked(); got != permission.Write {
+		t.Errorf("expected write to be revoked, got %v", got)
+	}
+
+	wantText := "--- main\n+++ b\n" +
+		"-alice-read|write\n+alice-read|execute\n" +
+		"-bob-execute\n" +
+		"-carol-read-!delete\n+carol-read\n" +
+		"+dave-write-!execute"
+	if got := patch.String(); got != wantText {
+		t.Errorf("wanted\n%s\ngot\n%s", wantText, got)
+	}
+
+	if !mustDiff(t, after, after).Empty() {
+		t.Errorf("expected no changes between an acl and itself")
+	}
+	if _, err := Diff(nil, after); !errors.Is(err, ErrNilAcl) {
+		t.Errorf("expected ErrNilAcl, got %v", err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	base, _ := Stoa("alice-read|write\nbob-execute\ncarol-read-!delete\nstaff+=bob")
+	target, _ := Stoa("alice-read|execute\ncarol-read\ndave-write-!execute")
+	patch := mustDiff(t, base, target)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := base.Watch(ctx)
+
+	if err := base.Apply(patch); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if !mustDiff(t, base, target).Empty() {
+		t.Errorf("expected %q to match %q", base, target)
+	}
+	if _, _, err := base.Check("bob"); err == nil {
+		t.Errorf("expected bob to be deregistered")
+	}
+
+	// alice: remove and insert, bob: deregister, carol: undeny,
+	// dave: register, insert and deny, staff: deregister.
+	if got := len(changes); got != 8 {
+		t.Errorf("expected 8 changes to be published, got %d", got)
+	}
+
+	if err := base.Apply(patch); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected reapplying to conflict, got %v", err)
+	}
+}
+
+func TestApplyMakesTemporaryGrantsPermanent(t *testing.T) {
+	fc := newFakeClock()
+	base, _ := Stoa("alice-read")
+	base.SetClock(fc.Now)
+	if _, err := base.InsertFor("alice", time.Hour, permission.Write); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	target, _ := Stoa("alice-read|write")
+	if err := base.Apply(mustDiff(t, base, target)); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+
+	fc.Advance(2 * time.Hour)
+	if wasSet, _, _ := base.Check("alice", permission.Write); len(wasSet) != 1 {
+		t.Errorf("expected the grant of write to have been made permanent")
+	}
+	if got, want := base.String(), target.String(); got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+}
+
+func TestApplyIsAtomicToChecks(t *testing.T) {
+	base, _ := Stoa("alice-read")
+	target, _ := Stoa("alice-read|delete-!delete")
+	base.Check("alice", permission.Delete)
+
+	// Checks do not take the lock, so the auditor can make
+	// them while Apply reports each of its changes.
+	var escalated bool
+	base.SetAuditor(audit.AuditorFunc(func(ev audit.Event) {
+		if ev.Op == OpCheck.String() {
+			return
+		}
+		if wasSet, _, _ := base.Check("alice", permission.Delete); len(wasSet) != 0 {
+			escalated = true
+		}
+	}))
+
+	if err := base.Apply(mustDiff(t, base, target)); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if escalated {
+		t.Errorf("expected delete never to be granted part way through the patch")
+	}
+	if err := base.Apply(nil); !errors.Is(err, ErrNilPatch) {
+		t.Errorf("expected ErrNilPatch, got %v", err)
+	}
+}
+
+func TestApplyConflicts(t *testing.T) {
+	base, _ := Stoa("alice-read\nbob-write")
+	target, _ := Stoa("alice-write\ncarol-read")
+	patch := mustDiff(t, base, target)
+
+	cases := []struct {
+		name  string
+		drift func(a *Acl)
+	}{
+		{name: "grant", drift: func(a *Acl) { a.Insert("alice", permission.Execute) }},
+		{name: "deny", drift: func(a *Acl) { a.Deny("alice", permission.Delete) }},
+		{name: "removed", drift: func(a *Acl) { a.DeRegisterUser("bob") }},
+		{name: "added", drift: func(a *Acl) { a.RegisterUser("carol") }},
+	}
+
+	for _, tc := range cases {
+		a, _ := Stoa(base.String())
+		tc.drift(a)
+		drifted := a.String()
+
+		if err := a.Apply(patch); !errors.Is(err, ErrConflict) {
+			t.Errorf("%s: expected ErrConflict, got %v", tc.name, err)
+		}
+		if got := a.String(); got != drifted {
+			t.Errorf("%s: expected the acl to be left alone, got %q", tc.name, got)
+		}
+	}
+}
+
+func TestApplyReachesTarget(t *testing.T) {
+	reaches := func(from, to quickAcl) bool {
+		patch := mustDiff(t, from.Acl, to.Acl)
+		if err := from.Apply(patch); err != nil {
+			t.Logf("%s: %v", patch, err)
+			return false
+		}
+		return mustDiff(t, from.Acl, to.Acl).Empty()
+	}
+
+	if err := quick.Check(reaches, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/odeke-em/acl/permission"
+	"github.com/odeke-em/acl/scope"
+)
+
+var (
+	ErrAlreadyExpired = errors.New("expiry is not in the future")
+	// ErrDurationTooShort is returned for temporary grants of less
+	// than a second, the granularity at which expiries are kept.
+	ErrDurationTooShort = errors.New("duration is shorter than a second")
+)
+
+// expiryMap holds, for each scope, the time at which every
+// temporarily granted bit stops being granted.
+type expiryMap map[scope.Scope]map[permission.Permission]time.Time
+
+// SetClock replaces the clock used to decide whether temporary
+// grants have expired. A nil clock restores time.Now.
+func (a *Acl) SetClock(now func() time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.setClockLocked(now)
+}
+
+// setClockLocked has to be called with a.mu held.
+func (a *Acl) setClockLocked(now func() time.Time) {
+	a.clock = now
+	a.reconfigure()
+	a.notify(nil)
+}
+
+// SetTTL sets the lifetime given to grants made through Insert.
+// A zero TTL, the default, makes those grants permanent. The TTL
+// has a granularity of one second, so d is rounded up to the second.
+func (a *Acl) SetTTL(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.setTTLLocked(d)
+}
+
+// setTTLLocked has to be called with a.mu held.
+func (a *Acl) setTTLLocked(d time.Duration) {
+	ev := event("", OpSetTTL, "")
+	a.ttl = 0
+	if d > 0 {
+		a.ttl = int64((d + time.Second - 1) / time.Second)
+	}
+	ev.ttl = time.Duration(a.ttl) * time.Second
+	a.emit(ev, nil)
+}
+
+// TTL returns the lifetime given to grants made through Insert.
+func (a *Acl) TTL() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return time.Duration(a.ttl) * time.Second
+}
+
+// InsertFor grants permissions to userId for the duration d, which has
+// to be at least a second. The expiry is rounded up to the second.
+func (a *Acl) InsertFor(userId string, d time.Duration, permissions ...permission.Permission) ([]permission.Permission, error) {
+	if d < time.Second {
+		return nil, fmt.Errorf("%w: %v", ErrDurationTooShort, d)
+	}
+
+	return a.insertTemporary("", userId, func(now time.Time) time.Time {
+		return ceilSecond(now.Add(d))
+	}, permissions)
+}
+
+// ceilSecond rounds t up to the second, so that a grant
+// made for a duration lasts at least that long.
+func ceilSecond(t time.Time) time.Time {
+	if truncated := t.Truncate(time.Second); truncated.Before(t) {
+		return truncated.Add(time.Second)
+	}
+	return t
+}
+
+// InsertUntil grants permissions to userId until the given time, which
+// is truncated to the second. Bits that are already granted permanently
+// are left alone and bits already granted for longer keep their expiry.
+// In text form a temporary grant is a permission segment suffixed with
+// "@" and its expiry in Unix seconds, e.g. "contractor-read@1700000000".
+func (a *Acl) InsertUntil(userId string, until time.Time, permissions ...permission.Permission) (added []permission.Permission, err error) {
+	return a.insertUntilFor("", userId, until, permissions)
+}
+
+func (a *Acl) insertUntilFor(actor, userId string, until time.Time, permissions []permission.Permission) (added []permission.Permission, err error) {
+	return a.insertTemporary(actor, userId, func(time.Time) time.Time { return until }, permissions)
+}
+
+// insertTemporary grants permissions to userId until the expiry that
+// it works out from the current time, under the same hold of the lock.
+func (a *Acl) insertTemporary(actor, userId string, expiry func(now time.Time) time.Time, permissions []permission.Permission) (added []permission.Permission, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ev := event(actor, OpInsert, userId)
+	defer func() { a.emit(ev, err) }()
+
+	sc, scErr := scope.New(userId)
+	if scErr != nil {
+		err = scErr
+		return
+	}
+
+	if _, ok := a.rules[sc]; !ok {
+		err = fmt.Errorf("no such userId %q found", userId)
+		return
+	}
+
+	return a.insertUntil(ev, sc, expiry(a.currentTime()), permissions)
+}
+
+// insertUntil grants permissions to sc until the given time,
+// recording the change on ev.
+func (a *Acl) insertUntil(ev *operation, sc scope.Scope, until time.Time, permissions []permission.Permission) (added []permission.Permission, err error) {
+	until = until.Truncate(time.Second)
+	ev.Expiry, ev.perms = until, union(permissions)
+	now := a.currentTime()
+	if !until.After(now) {
+		err = fmt.Errorf("%w: %v", ErrAlreadyExpired, until)
+		return
+	}
+
+	if a.expiries == nil {
+		a.expiries = make(expiryMap)
+	}
+
+	expiries, ok := a.expiries[sc]
+	if !ok {
+		expiries = make(map[permission.Permission]time.Time)
+		a.expiries[sc] = expiries
+	}
+
+	permanent := a.rules[sc]
+	held := permanent | a.temporary(sc, now)
+	ev.Before = held
+	defer func() { ev.After = a.held(sc) }()
+	for _, perm := range permissions {
+		if fresh := perm &^ held; fresh != permission.None {
+			added = append(added, fresh)
+			held |= fresh
+		}
+
+		forEachBit(perm&^permanent, func(bit permission.Permission) {
+			if cur, ok := expiries[bit]; !ok || cur.Before(until) {
+				expiries[bit] = until
+			}
+		})
+	}
+
+	return
+}
+
+// Sweep purges every temporary grant that has expired and
+// returns the number of permission bits that were purged.
+func (a *Acl) Sweep() int {
+	return a.sweep("")
+}
+
+func (a *Acl) sweep(actor string) (purged int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.currentTime()
+	for sc, expiries := range a.expiries {
+		ev := event(actor, OpSweep, sc.String())
+		for bit, until := range expiries {
+			if !until.After(now) {
+				ev.Before |= bit
+				delete(expiries, bit)
+				purged++
+			}
+		}
+
+		if ev.Before != permission.None {
+			ev.perms = ev.Before
+			a.emit(ev, nil)
+		}
+
+		if len(expiries) == 0 {
+			delete(a.expiries, sc)
+		}
+	}
+
+	return
+}
+
+// sweepScope purges the temporary grants of bits from userId, whether
+// they have expired or not, replaying what a Sweep did at the time.
+func (a *Acl) sweepScope(actor, userId string, bits permission.Permission) (err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ev := event(actor, OpSweep, userId)
+	defer func() { a.emit(ev, err) }()
+
+	sc, err := a.registered(userId)
+	if err != nil {
+		return
+	}
+
+	ev.Before, ev.perms = bits, bits
+	a.clearExpiries(sc, bits)
+	return
+}
+
+// temporary returns the bits temporarily granted to sc that are still live at now.
+func (a *Acl) temporary(sc scope.Scope, now time.Time) (live permission.Permission) {
+	for bit, until := range a.expiries[sc] {
+		if until.After(now) {
+			live |= bit
+		}
+	}
+
+	return
+}
+
+// clearExpiries drops the temporary grants of bits for sc.
+func (a *Acl) clearExpiries(sc scope.Scope, bits permission.Permission) {
+	expiries, ok := a.expiries[sc]
+	if !ok {
+		return
+	}
+
+	forEachBit(bits, func(bit permission.Permission) {
+		delete(expiries, bit)
+	})
+
+	if len(expiries) == 0 {
+		delete(a.expiries, sc)
+	}
+}
+
+func (a *Acl) currentTime() time.Time {
+	if a.clock == nil {
+		return time.Now()
+	}
+
+	return a.clock()
+}
+
+func forEachBit(p permission.Permission, fn func(permission.Permission)) {
+	for p != permission.None {
+		bit := p & -p
+		fn(bit)
+		p &^= bit
+	}
+}
+
+// formatExpiries returns the text form of the temporary grants
+// of sc, ordered by expiry.
+func (a *Acl) formatExpiries(sc scope.Scope, transform func(permission.Permission) permission.Permission) []string {
+	byExpiry := make(map[int64]permission.Permission)
+	for bit, until := range a.expiries[sc] {
+		byExpiry[until.Unix()] |= bit
+	}
+
+	untils := make([]int64, 0, len(byExpiry))
+	for until := range byExpiry {
+		untils = append(untils, until)
+	}
+	sort.Slice(untils, func(i, j int) bool { return untils[i] < untils[j] })
+
+	sects := make([]string, 0, len(untils))
+	for _, until := range untils {
+		sects = append(sects, fmt.Sprintf("%s%s%d", transform(byExpiry[until]), expiryDelimiter, until))
+	}
+
+	return sects
+}
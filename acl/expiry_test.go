@@ -0,0 +1,186 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	return fc.now
+}
+
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.now = fc.now.Add(d)
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func TestInsertForExpires(t *testing.T) {
+	fc := newFakeClock()
+	acl := Acl{}
+	acl.SetClock(fc.Now)
+
+	uid := "contractor"
+	if err := acl.RegisterUser(uid); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	added, err := acl.InsertFor(uid, time.Hour, permission.Write)
+	if err != nil || len(added) != 1 {
+		t.Fatalf("insertFor: added %v err %v", added, err)
+	}
+
+	if wasSet, _, _ := acl.Check(uid, permission.Write, permission.Read); len(wasSet) != 2 {
+		t.Errorf("expected the temporary grant to be live, got %v", wasSet)
+	}
+
+	if got, want := acl.String(), Header+"\ncontractor-write@1700003600"; got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+
+	fc.Advance(time.Hour)
+
+	if wasSet, _, _ := acl.Check(uid, permission.Write); len(wasSet) != 0 {
+		t.Errorf("expected the temporary grant to have expired, got %v", wasSet)
+	}
+
+	if purged := acl.Sweep(); purged != 1 {
+		t.Errorf("expected 1 purged bit, got %d", purged)
+	}
+
+	if got, want := acl.String(), Header+"\ncontractor"; got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+
+	if _, err := acl.InsertUntil(uid, fc.Now(), permission.Read); err == nil {
+		t.Errorf("expected an error for an expiry that is not in the future")
+	}
+}
+
+func TestInsertForRoundsUp(t *testing.T) {
+	fc := newFakeClock()
+	fc.Advance(500 * time.Millisecond)
+	acl := Acl{}
+	acl.SetClock(fc.Now)
+	if err := acl.RegisterUser("contractor"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	if _, err := acl.InsertFor("contractor", 500*time.Millisecond, permission.Read); !errors.Is(err, ErrDurationTooShort) {
+		t.Errorf("wanted ErrDurationTooShort got %v", err)
+	}
+	if _, err := acl.InsertFor("contractor", time.Second, permission.Read); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	// The grant lasts at least the second asked for.
+	fc.Advance(time.Second)
+	if wasSet, _, _ := acl.Check("contractor", permission.Read); len(wasSet) != 1 {
+		t.Errorf("expected the grant to hold for a whole second")
+	}
+	fc.Advance(500 * time.Millisecond)
+	if wasSet, _, _ := acl.Check("contractor", permission.Read); len(wasSet) != 0 {
+		t.Errorf("expected the grant to have expired at the next second")
+	}
+}
+
+func TestTTLAppliesToInsert(t *testing.T) {
+	fc := newFakeClock()
+	acl := Acl{}
+	acl.SetClock(fc.Now)
+	acl.SetTTL(time.Minute)
+
+	uid := "breakglass"
+	if err := acl.RegisterUser(uid); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	if _, err := acl.Insert(uid, permission.Execute); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	fc.Advance(30 * time.Second)
+	if wasSet, _, _ := acl.Check(uid, permission.Execute); len(wasSet) != 1 {
+		t.Errorf("expected execute to still be granted")
+	}
+
+	fc.Advance(30 * time.Second)
+	if wasSet, _, _ := acl.Check(uid, permission.Execute); len(wasSet) != 0 {
+		t.Errorf("expected execute to have expired")
+	}
+}
+
+func TestTTLRoundsUp(t *testing.T) {
+	fc := newFakeClock()
+	fc.Advance(500 * time.Millisecond)
+	acl := Acl{}
+	acl.SetClock(fc.Now)
+	acl.SetTTL(500 * time.Millisecond)
+	if got := acl.TTL(); got != time.Second {
+		t.Errorf("expected the TTL to be rounded up to a second, got %v", got)
+	}
+
+	if err := acl.RegisterUser("contractor"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := acl.Insert("contractor", permission.Read); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	// Like InsertFor, the grant lasts at least the TTL.
+	fc.Advance(time.Second)
+	if wasSet, _, _ := acl.Check("contractor", permission.Read); len(wasSet) != 1 {
+		t.Errorf("expected the grant to hold for a whole second")
+	}
+	fc.Advance(500 * time.Millisecond)
+	if wasSet, _, _ := acl.Check("contractor", permission.Read); len(wasSet) != 0 {
+		t.Errorf("expected the grant to have expired rather than be permanent")
+	}
+}
+
+func TestStoaExpiries(t *testing.T) {
+	fc := newFakeClock()
+
+	acl, err := Stoa("oncall-read-execute|list@1700000100-write@1699999999")
+	if err != nil {
+		t.Fatalf("expected a successful parse, got %v", err)
+	}
+	acl.SetClock(fc.Now)
+
+	wasSet, notSet, _ := acl.Check("oncall", permission.Execute, permission.Write)
+	if len(wasSet) != 1 || wasSet[0] != permission.Execute || len(notSet) != 1 {
+		t.Errorf("wasSet: %v notSet: %v", wasSet, notSet)
+	}
+
+	pass, _, err := acl.Remove("oncall", permission.Execute)
+	if err != nil || len(pass) != 1 {
+		t.Errorf("expected a live temporary grant to be removable, got %v %v", pass, err)
+	}
+
+	if _, err := Stoa("oncall-!read@1700000100"); err == nil {
+		t.Errorf("expected an error for an expiring denial")
+	}
+}
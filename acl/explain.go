@@ -0,0 +1,350 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/odeke-em/acl/permission"
+	"github.com/odeke-em/acl/scope"
+)
+
+// Decision is the outcome of checking a single permission.
+type Decision int
+
+const (
+	NotGranted Decision = iota
+	Granted
+	Denied
+)
+
+func (d Decision) String() string {
+	switch d {
+	case Granted:
+		return "granted"
+	case Denied:
+		return "denied"
+	default:
+		return "not granted"
+	}
+}
+
+// Cause is the kind of rule that bore on a decision.
+type Cause int
+
+const (
+	// CauseDirect is a permanent grant.
+	CauseDirect Cause = iota
+	// CauseTemporary is a grant that is live until its Expiry.
+	CauseTemporary
+	// CauseExpired is a temporary grant whose Expiry has passed.
+	CauseExpired
+	// CauseRole is a grant made through a Role.
+	CauseRole
+	// CauseDeny is an explicit denial.
+	CauseDeny
+)
+
+// Rule is a single grant or denial that bore on a decision.
+type Rule struct {
+	Cause Cause
+	// Scope is the scope that the rule is recorded on: either
+	// the principal itself or one of the groups it belongs to.
+	Scope string
+	// Via lists the groups leading from the principal to Scope.
+	Via []string
+	// Role is set for CauseRole.
+	Role string
+	// Resource is set for rules inherited through a Store.
+	Resource string
+	// Permission holds the requested bits that the rule bore on.
+	Permission permission.Permission
+	// Implied reports whether some of those bits were
+	// only reached through permission implication.
+	Implied bool
+	// Expiry is set for CauseTemporary and CauseExpired.
+	Expiry time.Time
+}
+
+func (r Rule) String() string {
+	var verb string
+	switch r.Cause {
+	case CauseDeny:
+		verb = "denied to"
+	case CauseRole:
+		verb = fmt.Sprintf("granted through role %q to", r.Role)
+	case CauseTemporary:
+		verb = fmt.Sprintf("granted until %s to", r.Expiry.UTC().Format(time.RFC3339))
+	case CauseExpired:
+		verb = fmt.Sprintf("granted until %s, now expired, to", r.Expiry.UTC().Format(time.RFC3339))
+	default:
+		verb = "granted to"
+	}
+
+	sects := []string{fmt.Sprintf("%s %s %q", r.Permission, verb, r.Scope)}
+	if len(r.Via) > 0 {
+		sects = append(sects, "via "+strings.Join(r.Via, " > "))
+	}
+	if r.Resource != "" {
+		sects = append(sects, fmt.Sprintf("on %q", r.Resource))
+	}
+	if r.Implied {
+		sects = append(sects, "(implied)")
+	}
+
+	return strings.Join(sects, " ")
+}
+
+// Verdict is the decision made for one requested permission
+// along with every rule that bore on it.
+type Verdict struct {
+	Permission permission.Permission
+	Decision   Decision
+	Rules      []Rule
+}
+
+// Explanation traces how the permissions of a principal were decided.
+type Explanation struct {
+	Principal string
+	Resource  string
+	Verdicts  []Verdict
+}
+
+// String renders the explanation for humans, one verdict per
+// paragraph followed by the rules behind it.
+func (e *Explanation) String() string {
+	header := fmt.Sprintf("%q", e.Principal)
+	if e.Resource != "" {
+		header = fmt.Sprintf("%s on %q", header, e.Resource)
+	}
+
+	lines := []string{header}
+	for _, v := range e.Verdicts {
+		lines = append(lines, fmt.Sprintf("  %s: %s", v.Permission, v.Decision))
+		if len(v.Rules) == 0 {
+			lines = append(lines, "    - no rule grants it")
+		}
+		for _, r := range v.Rules {
+			lines = append(lines, "    - "+r.String())
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Explain reports, for each of permissions, whether userId holds it
+// and which grants, roles, groups, denials or expired grants decided so.
+func (a *Acl) Explain(userId string, permissions ...permission.Permission) (*Explanation, error) {
+	rules, err := a.rulesFor(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return explain(userId, "", rules, permissions), nil
+}
+
+// Explain is like Check but explains each decision, including
+// the ancestor resources that contributed rules to it.
+func (s *Store) Explain(principal, resource string, permissions ...permission.Permission) (*Explanation, error) {
+	resources, acls := s.ancestry(resource)
+
+	var all []Rule
+	for i, aclV := range acls {
+		if aclV == nil {
+			continue
+		}
+
+		rules, err := aclV.rulesFor(principal)
+		if errors.Is(err, ErrUserDoesnotExist) || errors.Is(err, ErrUninitializedACL) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range rules {
+			r.Resource = resources[i]
+			all = append(all, r)
+		}
+	}
+
+	return explain(principal, resource, all, permissions), nil
+}
+
+// explain applies the same precedence as Check to rules, whose
+// Permission holds every bit they grant or deny, unimplied.
+func explain(principal, resource string, rules []Rule, permissions []permission.Permission) *Explanation {
+	e := &Explanation{Principal: principal, Resource: resource}
+
+	for _, perm := range permissions {
+		v := Verdict{Permission: perm}
+
+		var granted, denied permission.Permission
+		var grants, denials, expired []Rule
+		for _, r := range rules {
+			if r.Cause == CauseDeny {
+				if bits := r.Permission & perm; bits != permission.None {
+					denied |= bits
+					r.Permission = bits
+					denials = append(denials, r)
+				}
+				continue
+			}
+
+			closed := permission.Closure(r.Permission)
+			bits := closed & perm
+			if bits == permission.None {
+				continue
+			}
+
+			r.Implied = bits&^r.Permission != permission.None
+			r.Permission = bits
+			if r.Cause == CauseExpired {
+				expired = append(expired, r)
+				continue
+			}
+			granted |= bits
+			grants = append(grants, r)
+		}
+
+		switch {
+		case denied != permission.None:
+			v.Decision, v.Rules = Denied, denials
+		case granted&perm == perm:
+			v.Decision, v.Rules = Granted, grants
+		default:
+			v.Decision, v.Rules = NotGranted, append(grants, expired...)
+		}
+
+		e.Verdicts = append(e.Verdicts, v)
+	}
+
+	return e
+}
+
+// rulesFor returns every grant and denial recorded on userId
+// and on the groups that it belongs to.
+func (a *Acl) rulesFor(userId string) (rules []Rule, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.rules == nil {
+		err = ErrUninitializedACL
+		return
+	}
+
+	sc, err := scope.New(userId)
+	if err != nil {
+		return
+	}
+
+	if _, ok := a.rules[sc]; !ok {
+		err = ErrUserDoesnotExist
+		return
+	}
+
+	now := a.currentTime()
+	order, via := a.groupPaths(sc)
+	for _, principal := range order {
+		base := Rule{Scope: principal.String(), Via: via[principal]}
+
+		if perms := a.rules[principal]; perms != permission.None {
+			r := base
+			r.Cause, r.Permission = CauseDirect, perms
+			rules = append(rules, r)
+		}
+
+		for _, until := range sortedExpiries(a.expiries[principal]) {
+			r := base
+			r.Cause, r.Expiry = CauseTemporary, until
+			if !until.After(now) {
+				r.Cause = CauseExpired
+			}
+			for bit, bitUntil := range a.expiries[principal] {
+				if bitUntil.Equal(until) {
+					r.Permission |= bit
+				}
+			}
+			rules = append(rules, r)
+		}
+
+		names := make([]string, 0, len(a.assigned[principal]))
+		for name := range a.assigned[principal] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			r := base
+			r.Cause, r.Role, r.Permission = CauseRole, name, a.roleMask(name)
+			rules = append(rules, r)
+		}
+
+		if denied := a.denies[principal]; denied != permission.None {
+			r := base
+			r.Cause, r.Permission = CauseDeny, denied
+			rules = append(rules, r)
+		}
+	}
+
+	return
+}
+
+// groupPaths is like principals but visits groups in a stable order and
+// also returns, for each group, the chain of groups leading to it from sc.
+func (a *Acl) groupPaths(sc scope.Scope) (order []scope.Scope, via map[scope.Scope][]string) {
+	order = []scope.Scope{sc}
+	via = map[scope.Scope][]string{sc: nil}
+
+	for i := 0; i < len(order); i++ {
+		current := order[i]
+
+		groups := make([]scope.Scope, 0, len(a.memberOf[current]))
+		for group := range a.memberOf[current] {
+			groups = append(groups, group)
+		}
+		sort.Slice(groups, func(i, j int) bool { return groups[i].String() < groups[j].String() })
+
+		for _, group := range groups {
+			if _, ok := via[group]; ok {
+				continue
+			}
+
+			path := append(append([]string{}, via[current]...), group.String())
+			via[group] = path
+			order = append(order, group)
+		}
+	}
+
+	return
+}
+
+func sortedExpiries(expiries map[permission.Permission]time.Time) []time.Time {
+	seen := make(map[int64]struct{})
+	untils := []time.Time{}
+	for _, until := range expiries {
+		if _, ok := seen[until.UnixNano()]; ok {
+			continue
+		}
+		seen[until.UnixNano()] = emptyStruct
+		untils = append(untils, until)
+	}
+	sort.Slice(untils, func(i, j int) bool { return untils[i].Before(untils[j]) })
+
+	return untils
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+func TestExplain(t *testing.T) {
+	fc := newFakeClock()
+
+	acl, err := Stoa("viewer=list|read\nstaff-viewer:engineering-execute@1600000000:alice-write-!delete\nstaff+=engineering:engineering+=alice")
+	if err != nil {
+		t.Fatalf("expected a successful parse, got %v", err)
+	}
+	acl.SetClock(fc.Now)
+
+	e, err := acl.Explain("alice", permission.Read, permission.Delete, permission.Execute)
+	if err != nil {
+		t.Fatalf("explain: %v", err)
+	}
+
+	if len(e.Verdicts) != 3 {
+		t.Fatalf("expected 3 verdicts, got %d", len(e.Verdicts))
+	}
+
+	read := e.Verdicts[0]
+	if read.Decision != Granted || len(read.Rules) != 2 {
+		t.Errorf("read: %+v", read)
+	}
+	if r := read.Rules[0]; r.Cause != CauseDirect || r.Scope != "alice" || !r.Implied {
+		t.Errorf("read: expected an implied direct grant first, got %+v", r)
+	}
+	if r := read.Rules[1]; r.Cause != CauseRole || r.Role != "viewer" || strings.Join(r.Via, ">") != "engineering>staff" {
+		t.Errorf("read: expected a role granted through groups, got %+v", r)
+	}
+
+	if del := e.Verdicts[1]; del.Decision != Denied || len(del.Rules) != 1 || del.Rules[0].Cause != CauseDeny {
+		t.Errorf("delete: %+v", del)
+	}
+
+	if exec := e.Verdicts[2]; exec.Decision != NotGranted || len(exec.Rules) != 1 || exec.Rules[0].Cause != CauseExpired {
+		t.Errorf("execute: %+v", exec)
+	}
+
+	text := e.String()
+	for _, want := range []string{"read: granted", "delete: denied", "execute: not granted", "now expired", "via engineering > staff"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in\n%s", want, text)
+		}
+	}
+
+	if _, err := acl.Explain("nobody", permission.Read); err == nil {
+		t.Errorf("expected an error for an unregistered user")
+	}
+}
+
+func TestStoreExplain(t *testing.T) {
+	store := NewStore()
+	store.Acl("/").RegisterUser("alice")
+	store.Acl("/").Insert("alice", permission.Read)
+	store.Acl("/docs").RegisterUser("alice")
+
+	e, err := store.Explain("alice", "/docs/x", permission.Read)
+	if err != nil {
+		t.Fatalf("explain: %v", err)
+	}
+
+	if v := e.Verdicts[0]; v.Decision != Granted || len(v.Rules) != 1 || v.Rules[0].Resource != "/" {
+		t.Errorf("expected read to be inherited from /, got %+v", v)
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+// Header is the first line of the text form written by String.
+//
+// After it every line holds one rule:
+//
+//	role=permission|role           defines a role
+//	scope-permission|role          grants permissions and assigns roles
+//	scope-permission@unixseconds   grants permissions until a time
+//	scope-!permission              denies permissions
+//	group+=member,member           adds members to a group
+//
+// A scope may carry several of its rules on one line, separated by
+// "-", and a bare scope only registers it. Within scopes, and so group
+// and member names, "\", "-", "=", "+", "," and "#" are escaped with a
+// "\", while newlines and carriage returns are written as "\n" and
+// "\r". A ":" joins the parts of a hierarchical scope such as
+// "public:private" rather than separating rules. Lines starting with
+// "#" after the header are comments.
+//
+// Text without a header is read in the legacy format, where ":" also
+// separates rules and nothing is escaped.
+const Header = headerPrefix + "1"
+
+const (
+	// FormatVersion is the version of the text form written by String.
+	FormatVersion = 1
+
+	headerPrefix  = "#acl v"
+	commentPrefix = "#"
+	escapeChar    = '\\'
+
+	legacyVersion = 0
+)
+
+var ErrUnsupportedVersion = errors.New("unsupported format version")
+
+// parseHeader returns the version named by the first non-blank line of
+// lines and the lines that follow the header, or legacyVersion and all
+// of lines if there is no header.
+func parseHeader(lines []string) (version int, rest []string, err error) {
+	for i, line := range lines {
+		trimmed := strings.Trim(line, " \r")
+		if trimmed == "" {
+			continue
+		}
+		if !strings.HasPrefix(trimmed, headerPrefix) {
+			break
+		}
+
+		version, err = strconv.Atoi(strings.TrimPrefix(trimmed, headerPrefix))
+		if err == nil && version != FormatVersion {
+			err = fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+		} else if err != nil {
+			err = fmt.Errorf("%w: %q", ErrUnsupportedVersion, trimmed)
+		}
+		return version, lines[i+1:], err
+	}
+
+	return legacyVersion, lines, nil
+}
+
+// escapeName escapes the characters of a scope value that would
+// otherwise be read as part of the rule around it.
+func escapeName(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\', '-', '=', '+', ',', '#':
+			b.WriteRune(escapeChar)
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// unescapeName undoes escapeName.
+func unescapeName(s string) string {
+	if !strings.ContainsRune(s, escapeChar) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != escapeChar || i+1 == len(s) {
+			b.WriteByte(c)
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String()
+}
+
+// indexUnescaped returns the index of the first occurrence of
+// sep in s that is not escaped, or -1.
+func indexUnescaped(s, sep string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == escapeChar {
+			i++
+			continue
+		}
+		if strings.HasPrefix(s[i:], sep) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// splitUnescaped splits s around the occurrences of sep that are
+// not escaped, leaving the escapes in the parts.
+func splitUnescaped(s, sep string) []string {
+	parts := []string{}
+	for {
+		i := indexUnescaped(s, sep)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+len(sep):]
+	}
+}
+
+// Equal reports whether a and b hold the same registered scopes,
+// grants, temporary grants, denials, roles, role assignments and
+// memberships. Settings such as the clock, TTL and auditor are
+// not compared.
+func (a *Acl) Equal(b *Acl) bool {
+	if a == nil || b == nil || a == b {
+		return a == b
+	}
+
+	return reflect.DeepEqual(a.policy(), b.policy())
+}
+
+// policy is a copy of the rules of an Acl that compares
+// equal for Acls that hold the same rules.
+type policy struct {
+	rules    map[string]permission.Permission
+	denies   map[string]permission.Permission
+	expiries map[string]map[permission.Permission]int64
+	roles    map[string]rolePolicy
+	assigned map[string][]string
+	members  map[string][]string
+}
+
+type rolePolicy struct {
+	perms    permission.Permission
+	includes []string
+}
+
+// roleMask returns the permissions granted by the role name in p,
+// including those of the roles that it includes.
+func (p policy) roleMask(name string) permission.Permission {
+	r, ok := p.roles[name]
+	if !ok {
+		return permission.None
+	}
+
+	mask := r.perms
+	for _, included := range r.includes {
+		mask |= p.roleMask(included)
+	}
+
+	return mask
+}
+
+func (a *Acl) policy() policy {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p := policy{
+		rules:    make(map[string]permission.Permission),
+		denies:   make(map[string]permission.Permission),
+		expiries: make(map[string]map[permission.Permission]int64),
+		roles:    make(map[string]rolePolicy),
+		assigned: make(map[string][]string),
+		members:  make(map[string][]string),
+	}
+
+	for sc, perms := range a.rules {
+		p.rules[sc.String()] = perms
+	}
+	for sc, perms := range a.denies {
+		if perms != permission.None {
+			p.denies[sc.String()] = perms
+		}
+	}
+	for sc, expiries := range a.expiries {
+		if len(expiries) == 0 {
+			continue
+		}
+		untils := make(map[permission.Permission]int64, len(expiries))
+		for bit, until := range expiries {
+			untils[bit] = until.Unix()
+		}
+		p.expiries[sc.String()] = untils
+	}
+	for name, r := range a.roles {
+		p.roles[name] = rolePolicy{perms: r.perms, includes: sortedNames(r.includes)}
+	}
+	for sc, names := range a.assigned {
+		if len(names) > 0 {
+			p.assigned[sc.String()] = sortedNames(names)
+		}
+	}
+	for gsc, set := range a.members {
+		if len(set) == 0 {
+			continue
+		}
+		members := make([]string, 0, len(set))
+		for msc := range set {
+			members = append(members, msc.String())
+		}
+		sort.Strings(members)
+		p.members[gsc.String()] = members
+	}
+
+	return p
+}
+
+func sortedNames(set map[string]struct{}) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
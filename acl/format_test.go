@@ -0,0 +1,193 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+// quickNames includes every character that the text form escapes.
+var quickNames = []string{
+	"alice", "bob", "a-b", "x=y", "g+=h", "c,d", `back\slash`, `trailing\`,
+	"new\nline", "cr\rlf", "public:private", "#hash", "my org", "!bang", "at@1",
+}
+
+var quickPermissions = []permission.Permission{
+	permission.List, permission.Read, permission.Write, permission.Execute, permission.Delete,
+}
+
+// quickAcl is an Acl built from a random sequence of mutations.
+type quickAcl struct {
+	*Acl
+}
+
+func (quickAcl) Generate(r *rand.Rand, size int) reflect.Value {
+	fc := newFakeClock()
+	a := &Acl{}
+	a.SetClock(fc.Now)
+
+	name := func() string { return quickNames[r.Intn(len(quickNames))] }
+	perms := func() permission.Permission {
+		p := permission.None
+		for _, bit := range quickPermissions {
+			if r.Intn(3) == 0 {
+				p |= bit
+			}
+		}
+		return p
+	}
+	roles := []string{"viewer", "editor", "admin"}
+
+	for i := 0; i < size; i++ {
+		switch r.Intn(9) {
+		case 0, 1:
+			a.RegisterUser(name())
+		case 2:
+			a.Insert(name(), perms())
+		case 3:
+			a.Deny(name(), perms())
+		case 4:
+			a.InsertFor(name(), time.Duration(1+r.Intn(100))*time.Minute, perms())
+		case 5:
+			a.Remove(name(), perms())
+		case 6:
+			role := roles[r.Intn(len(roles))]
+			includes := []string{}
+			if other := roles[r.Intn(len(roles))]; other != role && r.Intn(2) == 0 {
+				includes = append(includes, other)
+			}
+			a.DefineRole(role, perms(), includes...)
+		case 7:
+			a.AssignRole(name(), roles[r.Intn(len(roles))])
+		case 8:
+			a.AddMember(name(), name())
+		}
+	}
+
+	return reflect.ValueOf(quickAcl{a})
+}
+
+func TestStringRoundTrips(t *testing.T) {
+	roundTrips := func(q quickAcl) bool {
+		text := q.String()
+		parsed, err := Stoa(text)
+		if err != nil {
+			t.Logf("%q: %v", text, err)
+			return false
+		}
+		if !parsed.Equal(q.Acl) {
+			t.Logf("%q parsed as %q", text, parsed.String())
+			return false
+		}
+		return parsed.String() == text
+	}
+
+	if err := quick.Check(roundTrips, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMinimalAndExpandedAreEquivalent(t *testing.T) {
+	equivalent := func(q quickAcl) bool {
+		minimal, mErr := Stoa(q.Minimal())
+		expanded, eErr := Stoa(q.Expanded())
+		if mErr != nil || eErr != nil {
+			return false
+		}
+		minimal.SetClock(q.clock)
+		expanded.SetClock(q.clock)
+
+		for _, name := range quickNames {
+			for _, perm := range quickPermissions {
+				want, _, _ := q.Check(name, perm)
+				gotMinimal, _, _ := minimal.Check(name, perm)
+				gotExpanded, _, _ := expanded.Check(name, perm)
+				if len(gotMinimal) != len(want) || len(gotExpanded) != len(want) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	if err := quick.Check(equivalent, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStringEscapesScopes(t *testing.T) {
+	acl := Acl{}
+	for _, uid := range []string{"a-b", "new\nline", "public:private"} {
+		acl.RegisterUser(uid)
+		acl.Insert(uid, permission.Read)
+	}
+	acl.RegisterUser("g+=h")
+	acl.RegisterUser("c,d")
+	acl.AddMember("g+=h", "c,d")
+
+	want := Header + "\na\\-b-read\nc\\,d\ng\\+\\=h\nnew\\nline-read\npublic:private-read\ng\\+\\=h+=c\\,d"
+	if got := acl.String(); got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+}
+
+func TestStoaVersions(t *testing.T) {
+	legacy, err := Stoa("public:private-read")
+	if err != nil {
+		t.Fatalf("legacy: %v", err)
+	}
+	if got := len(legacy.rules); got != 2 {
+		t.Errorf("expected the legacy format to split on %q, got %d scopes", scopeDelimiter, got)
+	}
+
+	current, err := Stoa("\n" + Header + "\n# a comment\npublic:private-read\n")
+	if err != nil {
+		t.Fatalf("current: %v", err)
+	}
+	if wasSet, _, _ := current.Check("public:private", permission.Read); len(wasSet) != 1 {
+		t.Errorf("expected public:private to be a single scope, got %q", current)
+	}
+
+	for _, header := range []string{"#acl v2", "#acl vx"} {
+		if _, err := Stoa(header + "\nalice-read"); !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("%q: expected ErrUnsupportedVersion, got %v", header, err)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	a, _ := Stoa("viewer=read\nalice-write-viewer-!delete\nstaff+=alice")
+	b, _ := Stoa(Header + "\nstaff+=alice\nalice-viewer|write-!delete\nviewer=read")
+	if !a.Equal(b) || !b.Equal(a) {
+		t.Errorf("expected %q and %q to be equal", a, b)
+	}
+
+	b.Undeny("alice", permission.Delete)
+	if a.Equal(b) {
+		t.Errorf("expected %q and %q to differ", a, b)
+	}
+
+	var nilAcl *Acl
+	if !nilAcl.Equal(nil) || a.Equal(nil) || nilAcl.Equal(a) {
+		t.Errorf("expected only nil to equal nil")
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/odeke-em/acl/common"
+	"github.com/odeke-em/acl/permission"
+	"github.com/odeke-em/acl/scope"
+)
+
+var (
+	ErrAlreadyMember   = errors.New("already a member")
+	ErrNotMember       = errors.New("not a member")
+	ErrMembershipCycle = errors.New("membership cycle")
+)
+
+var emptyStruct = struct{}{}
+
+// memberMap maps a scope to a set of scopes.
+type memberMap map[scope.Scope]map[scope.Scope]struct{}
+
+func (m memberMap) add(from, to scope.Scope) {
+	set, ok := m[from]
+	if !ok {
+		set = make(map[scope.Scope]struct{})
+		m[from] = set
+	}
+	set[to] = emptyStruct
+}
+
+func (m memberMap) remove(from, to scope.Scope) {
+	set, ok := m[from]
+	if !ok {
+		return
+	}
+	delete(set, to)
+	if len(set) == 0 {
+		delete(m, from)
+	}
+}
+
+// AddMember makes userId a member of group. Both have to be registered
+// and group may itself be a member of other groups, as long as that
+// does not make a group a member of itself. Members hold every
+// permission granted to their groups. In text form membership is
+// written as "group+=member,member".
+func (a *Acl) AddMember(group, userId string) error {
+	return a.addMemberAs("", group, userId)
+}
+
+func (a *Acl) addMemberAs(actor, group, userId string) (err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ev := event(actor, OpAddMember, group)
+	ev.Subject = userId
+	defer func() { a.emit(ev, err) }()
+
+	gsc, msc, err := a.registeredPair(group, userId)
+	if err != nil {
+		return err
+	}
+
+	return a.addMember(gsc, msc)
+}
+
+// RemoveMember ends the membership of userId in group.
+func (a *Acl) RemoveMember(group, userId string) error {
+	return a.removeMember("", group, userId)
+}
+
+func (a *Acl) removeMember(actor, group, userId string) (err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ev := event(actor, OpRemoveMember, group)
+	ev.Subject = userId
+	defer func() { a.emit(ev, err) }()
+
+	gsc, msc, err := a.registeredPair(group, userId)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := a.members[gsc][msc]; !ok {
+		return fmt.Errorf("%w: %q of %q", ErrNotMember, userId, group)
+	}
+
+	a.members.remove(gsc, msc)
+	a.memberOf.remove(msc, gsc)
+	return nil
+}
+
+func (a *Acl) registeredPair(group, userId string) (gsc, msc scope.Scope, err error) {
+	if a.rules == nil {
+		err = ErrUninitializedACL
+		return
+	}
+
+	if gsc, err = scope.New(group); err != nil {
+		return
+	}
+	if msc, err = scope.New(userId); err != nil {
+		return
+	}
+
+	for _, sc := range []scope.Scope{gsc, msc} {
+		if _, ok := a.rules[sc]; !ok {
+			err = fmt.Errorf("%w: %q", ErrUserDoesnotExist, sc)
+			return
+		}
+	}
+
+	return
+}
+
+func (a *Acl) addMember(gsc, msc scope.Scope) error {
+	if _, ok := a.members[gsc][msc]; ok {
+		return fmt.Errorf("%w: %q of %q", ErrAlreadyMember, msc, gsc)
+	}
+
+	// msc may not already be gsc or one of the groups that gsc belongs to.
+	for _, principal := range a.principals(gsc) {
+		if principal == msc {
+			return fmt.Errorf("%w: %q is already a member of %q", ErrMembershipCycle, gsc, msc)
+		}
+	}
+
+	if a.members == nil {
+		a.members = make(memberMap)
+	}
+	if a.memberOf == nil {
+		a.memberOf = make(memberMap)
+	}
+
+	a.members.add(gsc, msc)
+	a.memberOf.add(msc, gsc)
+	return nil
+}
+
+// principals returns sc followed by every group that it
+// belongs to, directly or through other groups.
+func (a *Acl) principals(sc scope.Scope) []scope.Scope {
+	all := []scope.Scope{sc}
+	seen := map[scope.Scope]struct{}{sc: emptyStruct}
+
+	for i := 0; i < len(all); i++ {
+		for group := range a.memberOf[all[i]] {
+			if _, ok := seen[group]; ok {
+				continue
+			}
+			seen[group] = emptyStruct
+			all = append(all, group)
+		}
+	}
+
+	return all
+}
+
+// dropMemberships removes sc from every group and empties sc if it is a group.
+func (a *Acl) dropMemberships(sc scope.Scope) {
+	for group := range a.memberOf[sc] {
+		a.members.remove(group, sc)
+	}
+	for member := range a.members[sc] {
+		a.memberOf.remove(member, sc)
+	}
+
+	delete(a.memberOf, sc)
+	delete(a.members, sc)
+}
+
+// parseMembership parses a "group+=member,member" rule,
+// registering the group and its members as needed.
+func (a *Acl) parseMembership(rule string) (err error) {
+	i := indexUnescaped(rule, membershipDelimiter)
+	groupStr, membersStr := unescapeName(rule[:i]), rule[i+len(membershipDelimiter):]
+
+	gsc, gErr := scope.Atos(groupStr)
+	if gErr != nil {
+		return fmt.Errorf("groupStr: %q err: %s", groupStr, gErr.Error())
+	}
+	a.ensureRegistered(gsc)
+
+	for _, memberStr := range splitUnescaped(membersStr, memberDelimiter) {
+		if strings.Trim(memberStr, " ") == "" {
+			continue
+		}
+		memberStr = unescapeName(memberStr)
+
+		msc, mErr := scope.Atos(memberStr)
+		if mErr != nil {
+			err = common.ReComposeError(err, fmt.Sprintf("memberStr: %q err: %s", memberStr, mErr.Error()))
+			continue
+		}
+		a.ensureRegistered(msc)
+
+		if mErr := a.addMember(gsc, msc); mErr != nil && !errors.Is(mErr, ErrAlreadyMember) {
+			err = common.ReComposeError(err, mErr.Error())
+		}
+	}
+
+	return
+}
+
+func (a *Acl) ensureRegistered(sc scope.Scope) {
+	if _, ok := a.rules[sc]; !ok {
+		a.rules[sc] = permission.None
+	}
+}
+
+// formatMemberships returns the text form of every group's membership,
+// ordered by group and then by member.
+func (a *Acl) formatMemberships() []string {
+	groups := make([]string, 0, len(a.members))
+	byGroup := make(map[string][]string, len(a.members))
+	for gsc, set := range a.members {
+		group := escapeName(gsc.String())
+		groups = append(groups, group)
+		for msc := range set {
+			byGroup[group] = append(byGroup[group], escapeName(msc.String()))
+		}
+		sort.Strings(byGroup[group])
+	}
+	sort.Strings(groups)
+
+	rules := make([]string, 0, len(groups))
+	for _, group := range groups {
+		rules = append(rules, group+membershipDelimiter+strings.Join(byGroup[group], memberDelimiter))
+	}
+
+	return rules
+}
@@ -0,0 +1,108 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+func TestGroupPermissions(t *testing.T) {
+	acl, err := Stoa("staff-read:engineering-execute:contractors-!execute:alice:bob\nstaff+=engineering\nengineering+=alice,bob:contractors+=bob")
+	if err != nil {
+		t.Fatalf("expected a successful parse, got %v", err)
+	}
+
+	cases := []struct {
+		user   string
+		perm   permission.Permission
+		set    bool
+		denied bool
+	}{
+		{user: "alice", perm: permission.Read, set: true},
+		{user: "alice", perm: permission.Execute, set: true},
+		{user: "alice", perm: permission.Write},
+		{user: "bob", perm: permission.Read, set: true},
+		{user: "bob", perm: permission.Execute, denied: true},
+		{user: "staff", perm: permission.Execute},
+	}
+
+	for _, tc := range cases {
+		wasSet, denied, _, err := acl.CheckDenied(tc.user, tc.perm)
+		if err != nil {
+			t.Errorf("%s %q: unexpected err %v", tc.user, tc.perm, err)
+		}
+
+		if got := len(wasSet) == 1; got != tc.set {
+			t.Errorf("%s %q: wanted set %v got %v", tc.user, tc.perm, tc.set, got)
+		}
+		if got := len(denied) == 1; got != tc.denied {
+			t.Errorf("%s %q: wanted denied %v got %v", tc.user, tc.perm, tc.denied, got)
+		}
+	}
+
+	want := Header + "\nalice\nbob\ncontractors-!execute\nengineering-execute\nstaff-read\ncontractors+=bob\nengineering+=alice,bob\nstaff+=engineering"
+	if got := acl.String(); got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+}
+
+func TestMembershipCycles(t *testing.T) {
+	acl := Acl{}
+	for _, uid := range []string{"a", "b", "c"} {
+		if err := acl.RegisterUser(uid); err != nil {
+			t.Fatalf("register %q: %v", uid, err)
+		}
+	}
+
+	if err := acl.AddMember("a", "b"); err != nil {
+		t.Fatalf("a+=b: %v", err)
+	}
+	if err := acl.AddMember("b", "c"); err != nil {
+		t.Fatalf("b+=c: %v", err)
+	}
+
+	if err := acl.AddMember("c", "a"); !errors.Is(err, ErrMembershipCycle) {
+		t.Errorf("c+=a: expected ErrMembershipCycle, got %v", err)
+	}
+	if err := acl.AddMember("a", "a"); !errors.Is(err, ErrMembershipCycle) {
+		t.Errorf("a+=a: expected ErrMembershipCycle, got %v", err)
+	}
+	if err := acl.AddMember("a", "b"); !errors.Is(err, ErrAlreadyMember) {
+		t.Errorf("a+=b: expected ErrAlreadyMember, got %v", err)
+	}
+	if err := acl.AddMember("a", "nobody"); !errors.Is(err, ErrUserDoesnotExist) {
+		t.Errorf("a+=nobody: expected ErrUserDoesnotExist, got %v", err)
+	}
+
+	if err := acl.RemoveMember("b", "c"); err != nil {
+		t.Errorf("b-=c: %v", err)
+	}
+	if err := acl.RemoveMember("b", "c"); !errors.Is(err, ErrNotMember) {
+		t.Errorf("b-=c: expected ErrNotMember, got %v", err)
+	}
+	if err := acl.AddMember("c", "a"); err != nil {
+		t.Errorf("c+=a once b-=c: %v", err)
+	}
+
+	if err := acl.DeRegisterUser("a"); err != nil {
+		t.Fatalf("deregister: %v", err)
+	}
+	if got, want := acl.String(), Header+"\nb\nc"; got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"iter"
+	"sort"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+// heldLine is a registered scope along with what it holds directly.
+type heldLine struct {
+	scope string
+	held  permission.Permission
+}
+
+// Users returns every registered scope, sorted.
+func (a *Acl) Users() []string {
+	lines := a.heldLines()
+
+	users := make([]string, 0, len(lines))
+	for _, line := range lines {
+		users = append(users, line.scope)
+	}
+	return users
+}
+
+// Permissions returns the permissions granted to userId directly, including
+// temporary grants that have not expired. Roles, groups and denials are not
+// taken into account; Check and Explain do that.
+func (a *Acl) Permissions(userId string) (permission.Permission, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	sc, err := a.registered(userId)
+	if err != nil {
+		return permission.None, err
+	}
+
+	return a.held(sc), nil
+}
+
+// All returns an iterator over every registered scope, in order, along
+// with the permissions that Permissions would return for it. It iterates
+// over a copy taken under the lock when the loop starts, so it sees any
+// concurrent mutation either entirely or not at all, and the body of the
+// loop may call back into a.
+func (a *Acl) All() iter.Seq2[string, permission.Permission] {
+	return func(yield func(string, permission.Permission) bool) {
+		for _, line := range a.heldLines() {
+			if !yield(line.scope, line.held) {
+				return
+			}
+		}
+	}
+}
+
+// heldLines copies, sorted by scope, what every registered scope holds directly.
+func (a *Acl) heldLines() []heldLine {
+	a.mu.Lock()
+	lines := make([]heldLine, 0, len(a.rules))
+	for sc := range a.rules {
+		lines = append(lines, heldLine{scope: sc.String(), held: a.held(sc)})
+	}
+	a.mu.Unlock()
+
+	sort.Slice(lines, func(i, j int) bool { return lines[i].scope < lines[j].scope })
+	return lines
+}
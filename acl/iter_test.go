@@ -0,0 +1,161 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+func TestUsersAndPermissions(t *testing.T) {
+	fc := newFakeClock()
+	acl, err := Stoa(Header + `
+editor=write
+carol-editor
+bob-read-!write
+alice-write`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	acl.SetClock(fc.Now)
+	if _, err := acl.InsertFor("carol", time.Hour, permission.Execute); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	if got, want := acl.Users(), []string{"alice", "bob", "carol"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("users: wanted %v got %v", want, got)
+	}
+
+	cases := []struct {
+		userId string
+		want   permission.Permission
+		err    error
+	}{
+		{userId: "alice", want: permission.Write},
+		{userId: "bob", want: permission.Read},
+		{userId: "carol", want: permission.Execute},
+		{userId: "dave", err: ErrUserDoesnotExist},
+	}
+
+	for _, tc := range cases {
+		got, err := acl.Permissions(tc.userId)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: wanted err %v got %v", tc.userId, tc.err, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: wanted %v got %v", tc.userId, tc.want, got)
+		}
+	}
+
+	fc.Advance(time.Hour)
+	if got, _ := acl.Permissions("carol"); got != permission.None {
+		t.Errorf("expected the temporary grant to have expired, got %v", got)
+	}
+
+	if _, err := (&Acl{}).Permissions("alice"); !errors.Is(err, ErrUninitializedACL) {
+		t.Errorf("wanted ErrUninitializedACL got %v", err)
+	}
+}
+
+func TestAll(t *testing.T) {
+	acl, err := Stoa(Header + `
+carol-list
+alice-write
+bob-read`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	var users []string
+	var perms []permission.Permission
+	for userId, held := range acl.All() {
+		users = append(users, userId)
+		perms = append(perms, held)
+
+		// The body may mutate the Acl it is ranging over.
+		if err := acl.RegisterUser(userId + "-copy"); err != nil {
+			t.Fatalf("register: %v", err)
+		}
+	}
+
+	if want := []string{"alice", "bob", "carol"}; !reflect.DeepEqual(users, want) {
+		t.Errorf("wanted %v got %v", want, users)
+	}
+	if want := []permission.Permission{permission.Write, permission.Read, permission.List}; !reflect.DeepEqual(perms, want) {
+		t.Errorf("wanted %v got %v", want, perms)
+	}
+
+	seen := 0
+	for range acl.All() {
+		seen++
+		if seen == 2 {
+			break
+		}
+	}
+	if seen != 2 {
+		t.Errorf("expected iteration to stop after 2, got %d", seen)
+	}
+}
+
+func TestAllIsConsistent(t *testing.T) {
+	left, _ := Stoa(Header + "\nx-read\ny")
+	right, _ := Stoa(Header + "\nx\ny-read")
+	toRight, toLeft := mustDiff(t, left, right), mustDiff(t, right, left)
+
+	acl, _ := Stoa(left.String())
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer close(done)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			p := toRight
+			if i%2 == 1 {
+				p = toLeft
+			}
+			if err := acl.Apply(p); err != nil {
+				t.Errorf("apply: %v", err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		readers := 0
+		for _, held := range acl.All() {
+			if held == permission.Read {
+				readers++
+			}
+		}
+		if readers != 1 {
+			t.Fatalf("#%d: saw %d readers in one iteration", i, readers)
+		}
+	}
+}
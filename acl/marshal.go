@@ -0,0 +1,314 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/odeke-em/acl/permission"
+	"github.com/odeke-em/acl/scope"
+)
+
+// aclJSON is the JSON form of an Acl:
+//
+//	{
+//	  "version": 1,
+//	  "name": "docs",
+//	  "uuid": "5f0c4a52-…",
+//	  "ttl": 3600,
+//	  "roles": {
+//	    "editor": {"permissions": ["write"], "includes": ["viewer"]},
+//	    "viewer": {"permissions": ["list", "read"]}
+//	  },
+//	  "scopes": {
+//	    "alice": {
+//	      "grant": ["execute"],
+//	      "roles": ["editor"],
+//	      "temporary": [{"permissions": ["delete"], "until": "2023-11-14T23:13:20Z"}],
+//	      "deny": ["write"]
+//	    },
+//	    "bob": {}
+//	  },
+//	  "groups": {"staff": ["alice", "bob"]}
+//	}
+//
+// The ttl is in seconds and every scope that is registered appears in
+// scopes. Permissions are lists of names, as permission.Permission
+// marshals them, and empty fields are left out.
+type aclJSON struct {
+	Version int                  `json:"version"`
+	Name    string               `json:"name,omitempty"`
+	UUID    string               `json:"uuid,omitempty"`
+	TTL     int64                `json:"ttl,omitempty"`
+	Roles   map[string]roleJSON  `json:"roles,omitempty"`
+	Scopes  map[string]scopeJSON `json:"scopes"`
+	Groups  map[string][]string  `json:"groups,omitempty"`
+}
+
+type roleJSON struct {
+	Permissions permission.Permission `json:"permissions"`
+	Includes    []string              `json:"includes,omitempty"`
+}
+
+type scopeJSON struct {
+	Grant     permission.Permission `json:"grant,omitempty"`
+	Roles     []string              `json:"roles,omitempty"`
+	Temporary []temporaryJSON       `json:"temporary,omitempty"`
+	Deny      permission.Permission `json:"deny,omitempty"`
+}
+
+type temporaryJSON struct {
+	Permissions permission.Permission `json:"permissions"`
+	Until       time.Time             `json:"until"`
+}
+
+// MarshalText encodes a in the text form returned by String.
+func (a *Acl) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText replaces the rules of a with those parsed by Stoa,
+// keeping its name, UUID, TTL, clock, auditor and watchers.
+func (a *Acl) UnmarshalText(text []byte) error {
+	parsed, err := Stoa(string(text))
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.replaceRules(parsed)
+	return nil
+}
+
+// MarshalJSON encodes a, together with its name, UUID and
+// TTL, in the schema documented on aclJSON.
+func (a *Acl) MarshalJSON() ([]byte, error) {
+	a.mu.Lock()
+	doc := a.toJSON()
+	a.mu.Unlock()
+
+	return json.Marshal(doc)
+}
+
+// UnmarshalJSON replaces the rules, name, UUID and TTL of a with the
+// decoded ones, keeping its clock, auditor and watchers.
+func (a *Acl) UnmarshalJSON(data []byte) error {
+	var doc aclJSON
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	decoded, err := doc.toAcl()
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.replaceRules(decoded)
+	a.name, a.uuid, a.ttl = decoded.name, decoded.uuid, decoded.ttl
+	return nil
+}
+
+// replaceRules swaps in the rules of b as a single OpReplace mutation.
+// It has to be called with a.mu held.
+func (a *Acl) replaceRules(b *Acl) {
+	defer a.emit(event("", OpReplace, ""), nil)
+
+	a.rules, a.denies, a.expiries = b.rules, b.denies, b.expiries
+	a.roles, a.assigned = b.roles, b.assigned
+	a.members, a.memberOf = b.members, b.memberOf
+	a.holders = nil
+	a.snap.Store(a.buildSnapshot())
+}
+
+func (a *Acl) toJSON() *aclJSON {
+	doc := &aclJSON{
+		Version: FormatVersion,
+		Name:    a.name,
+		UUID:    a.uuid,
+		TTL:     a.ttl,
+		Scopes:  make(map[string]scopeJSON, len(a.rules)),
+	}
+
+	if len(a.roles) > 0 {
+		doc.Roles = make(map[string]roleJSON, len(a.roles))
+		for name, r := range a.roles {
+			doc.Roles[name] = roleJSON{Permissions: r.perms, Includes: namesOrNil(r.includes)}
+		}
+	}
+
+	for sc, perms := range a.rules {
+		doc.Scopes[sc.String()] = scopeJSON{
+			Grant:     perms,
+			Roles:     namesOrNil(a.assigned[sc]),
+			Temporary: a.temporaryGrants(sc),
+			Deny:      a.denies[sc],
+		}
+	}
+
+	for gsc, set := range a.members {
+		if len(set) == 0 {
+			continue
+		}
+		if doc.Groups == nil {
+			doc.Groups = make(map[string][]string)
+		}
+
+		members := make([]string, 0, len(set))
+		for msc := range set {
+			members = append(members, msc.String())
+		}
+		sort.Strings(members)
+		doc.Groups[gsc.String()] = members
+	}
+
+	return doc
+}
+
+// temporaryGrants returns the temporary grants of sc, ordered by expiry.
+func (a *Acl) temporaryGrants(sc scope.Scope) []temporaryJSON {
+	byExpiry := make(map[int64]permission.Permission)
+	for bit, until := range a.expiries[sc] {
+		byExpiry[until.Unix()] |= bit
+	}
+
+	temporary := make([]temporaryJSON, 0, len(byExpiry))
+	for until, perms := range byExpiry {
+		temporary = append(temporary, temporaryJSON{Permissions: perms, Until: time.Unix(until, 0).UTC()})
+	}
+	sort.Slice(temporary, func(i, j int) bool { return temporary[i].Until.Before(temporary[j].Until) })
+
+	if len(temporary) == 0 {
+		return nil
+	}
+	return temporary
+}
+
+func namesOrNil(set map[string]struct{}) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	return sortedNames(set)
+}
+
+func (doc *aclJSON) toAcl() (*Acl, error) {
+	if doc.Version != FormatVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, doc.Version)
+	}
+
+	b := &Acl{
+		rules:    make(rulesMap),
+		denies:   make(rulesMap),
+		expiries: make(expiryMap),
+		name:     doc.Name,
+		uuid:     doc.UUID,
+		ttl:      doc.TTL,
+	}
+
+	// Included roles have to be defined before the roles including them.
+	visiting := make(map[string]bool)
+	var define func(name string) error
+	define = func(name string) error {
+		if _, ok := b.roles[name]; ok {
+			return nil
+		}
+		r, ok := doc.Roles[name]
+		if !ok {
+			return fmt.Errorf("%w: %q", ErrRoleDoesnotExist, name)
+		}
+		if visiting[name] {
+			return fmt.Errorf("%w: %q", ErrRoleCycle, name)
+		}
+		visiting[name] = true
+
+		for _, included := range r.Includes {
+			if err := define(included); err != nil {
+				return err
+			}
+		}
+		return b.defineRole(name, r.Permissions, r.Includes)
+	}
+
+	for _, name := range sortedKeys(doc.Roles) {
+		if err := define(name); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, key := range sortedKeys(doc.Scopes) {
+		sc, err := scope.Atos(key)
+		if err != nil {
+			return nil, fmt.Errorf("scope %q: %w", key, err)
+		}
+
+		s := doc.Scopes[key]
+		b.rules[sc] |= s.Grant
+		if s.Deny != permission.None {
+			b.denies[sc] |= s.Deny
+		}
+		for _, t := range s.Temporary {
+			if t.Until.IsZero() {
+				return nil, fmt.Errorf("scope %q: temporary %v has no expiry", key, t.Permissions)
+			}
+			b.setExpiry(sc, t.Permissions, t.Until.Truncate(time.Second))
+		}
+		for _, name := range s.Roles {
+			if _, ok := b.roles[name]; !ok {
+				return nil, fmt.Errorf("scope %q: %w: %q", key, ErrRoleDoesnotExist, name)
+			}
+			b.assignRole(sc, name)
+		}
+	}
+
+	for _, group := range sortedKeys(doc.Groups) {
+		gsc, err := scope.Atos(group)
+		if err != nil {
+			return nil, fmt.Errorf("group %q: %w", group, err)
+		}
+		b.ensureRegistered(gsc)
+
+		for _, member := range doc.Groups[group] {
+			msc, err := scope.Atos(member)
+			if err != nil {
+				return nil, fmt.Errorf("member %q: %w", member, err)
+			}
+			b.ensureRegistered(msc)
+
+			if err := b.addMember(gsc, msc); err != nil && !errors.Is(err, ErrAlreadyMember) {
+				return nil, err
+			}
+		}
+	}
+
+	return b, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,182 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/odeke-em/acl/audit"
+	"github.com/odeke-em/acl/permission"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	fc := newFakeClock()
+	acl := Acl{}
+	acl.SetClock(fc.Now)
+	acl.SetName("docs")
+	acl.SetTTL(time.Hour)
+
+	acl.DefineRole("viewer", permission.Read)
+	acl.DefineRole("editor", permission.Write, "viewer")
+	for _, uid := range []string{"alice", "bob", "staff"} {
+		acl.RegisterUser(uid)
+	}
+	acl.AssignRole("alice", "editor")
+	acl.InsertUntil("alice", fc.Now().Add(time.Hour), permission.Delete)
+	acl.Deny("alice", permission.Write)
+	acl.AddMember("staff", "alice")
+	acl.AddMember("staff", "bob")
+
+	data, err := json.Marshal(&acl)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	want := `{"version":1,"name":"docs","ttl":3600,` +
+		`"roles":{"editor":{"permissions":["write"],"includes":["viewer"]},"viewer":{"permissions":["read"]}},` +
+		`"scopes":{"alice":{"roles":["editor"],"temporary":[{"permissions":["delete"],"until":"2023-11-14T23:13:20Z"}],"deny":["write"]},"bob":{},"staff":{}},` +
+		`"groups":{"staff":["alice","bob"]}}`
+	if got := string(data); got != want {
+		t.Errorf("wanted\n%s\ngot\n%s", want, got)
+	}
+
+	var decoded Acl
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !decoded.Equal(&acl) {
+		t.Errorf("wanted %q got %q", &acl, &decoded)
+	}
+	if decoded.Name() != "docs" || decoded.TTL() != time.Hour {
+		t.Errorf("expected the name and TTL to be kept, got %q %v", decoded.Name(), decoded.TTL())
+	}
+}
+
+func TestMarshalJSONRoundTrips(t *testing.T) {
+	roundTrips := func(q quickAcl) bool {
+		data, err := json.Marshal(q.Acl)
+		if err != nil {
+			return false
+		}
+
+		var decoded Acl
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Logf("%s: %v", data, err)
+			return false
+		}
+		return decoded.Equal(q.Acl)
+	}
+
+	if err := quick.Check(roundTrips, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewAssignsUUID(t *testing.T) {
+	a, _ := New("alice-read")
+	b, _ := New("alice-read")
+	if a.UUID() == "" || a.UUID() == b.UUID() {
+		t.Errorf("expected distinct UUIDs, got %q and %q", a.UUID(), b.UUID())
+	}
+
+	data, _ := json.Marshal(a)
+	var decoded Acl
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.UUID() != a.UUID() {
+		t.Errorf("expected the UUID %q to be kept, got %q %v", a.UUID(), decoded.UUID(), err)
+	}
+}
+
+func TestUnmarshalJSONErrors(t *testing.T) {
+	cases := []struct {
+		doc  string
+		want error
+	}{
+		{doc: `{"version":2,"scopes":{}}`, want: ErrUnsupportedVersion},
+		{doc: `{"version":1,"scopes":{"alice":{"roles":["editor"]}}}`, want: ErrRoleDoesnotExist},
+		{doc: `{"version":1,"roles":{"a":{"permissions":[],"includes":["b"]},"b":{"permissions":[],"includes":["a"]}},"scopes":{}}`, want: ErrRoleCycle},
+		{doc: `{"version":1,"scopes":{},"groups":{"a":["b"],"b":["a"]}}`, want: ErrMembershipCycle},
+		{doc: `{"version":1,"scopes":{"alice":{"grant":["nope"]}}}`},
+	}
+
+	for _, tc := range cases {
+		acl, _ := Stoa("bob-read")
+		err := json.Unmarshal([]byte(tc.doc), acl)
+		if err == nil || (tc.want != nil && !errors.Is(err, tc.want)) {
+			t.Errorf("%s: expected %v, got %v", tc.doc, tc.want, err)
+		}
+		if got, want := acl.String(), Header+"\nbob-read"; got != want {
+			t.Errorf("%s: expected the acl to be left alone, got %q", tc.doc, got)
+		}
+	}
+}
+
+func TestMarshalText(t *testing.T) {
+	acl, _ := Stoa("viewer=read\nalice-viewer-!delete")
+	acl.SetName("docs")
+
+	text, err := acl.MarshalText()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	var decoded Acl
+	decoded.SetName("kept")
+	if err := decoded.UnmarshalText(text); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !decoded.Equal(acl) || decoded.Name() != "kept" {
+		t.Errorf("wanted %q named kept, got %q named %q", acl, &decoded, decoded.Name())
+	}
+}
+
+func TestUnmarshalIsAMutation(t *testing.T) {
+	acl, _ := Stoa("alice-read")
+
+	var events []audit.Event
+	acl.SetAuditor(audit.AuditorFunc(func(ev audit.Event) { events = append(events, ev) }))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := acl.Watch(ctx)
+
+	for i, unmarshal := range []func() error{
+		func() error { return acl.UnmarshalText([]byte("bob-write")) },
+		func() error { return acl.UnmarshalJSON([]byte(`{"version":1,"scopes":{"carol":{"grant":["list"]}}}`)) },
+	} {
+		if err := unmarshal(); err != nil {
+			t.Fatalf("#%d: unmarshal: %v", i, err)
+		}
+
+		change := <-changes
+		if want := uint64(i + 1); change.Seq != want || change.Op != OpReplace {
+			t.Errorf("#%d: wanted replace at %d, got %v at %d", i, want, change.Op, change.Seq)
+		}
+		if text, seq := acl.Versioned(); seq != change.Seq || text != acl.String() {
+			t.Errorf("#%d: Versioned is at %d, wanted %d", i, seq, change.Seq)
+		}
+		if err := (&Acl{}).Mutate(change.Mutation); !errors.Is(err, ErrNotReplayable) {
+			t.Errorf("#%d: wanted ErrNotReplayable got %v", i, err)
+		}
+	}
+
+	if len(events) != 2 || events[0].Op != "replace" || events[1].Op != "replace" {
+		t.Errorf("expected two replace events, got %+v", events)
+	}
+}
@@ -0,0 +1,328 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+var ErrUnknownMergePolicy = errors.New("unknown merge policy")
+
+// MergePolicy decides how Merge resolves the rules of a scope that
+// more than one source holds.
+type MergePolicy int
+
+const (
+	// MergeUnion grants what any source grants. A denial is kept
+	// only for bits that no source grants.
+	MergeUnion MergePolicy = iota
+	// MergeIntersection keeps only the scopes, role assignments and
+	// memberships held by every source, granting what every source
+	// grants and denying what any source denies.
+	MergeIntersection
+	// MergeLastWriterWins takes each scope, role and group wholesale
+	// from the last source that has it.
+	MergeLastWriterWins
+	// MergeDenyOverrides grants what any source grants
+	// and denies what any source denies.
+	MergeDenyOverrides
+)
+
+// Merged is the Acl that Merge assembles along with where its grants came from.
+type Merged struct {
+	*Acl
+	// Provenance maps each scope to the sources that contributed
+	// to its grants, in source order.
+	Provenance map[string][]Contribution
+}
+
+// Contribution records the permissions that a source of Merge
+// contributed to the grants of a scope.
+type Contribution struct {
+	// Source is the name of the source Acl or, if it has none,
+	// its position as "#0", "#1"...
+	Source     string
+	Permission permission.Permission
+}
+
+// Sources returns the sources that granted perms to userId.
+func (m *Merged) Sources(userId string, perms permission.Permission) []Contribution {
+	sources := []Contribution{}
+	for _, c := range m.Provenance[userId] {
+		if contributed := c.Permission & perms; contributed != permission.None {
+			sources = append(sources, Contribution{Source: c.Source, Permission: contributed})
+		}
+	}
+
+	return sources
+}
+
+// Merge assembles a new Acl from the registered scopes, grants,
+// temporary grants, denials, roles and memberships of acls, later
+// sources coming after earlier ones, according to p. None of acls may be nil.
+func Merge(p MergePolicy, acls ...*Acl) (*Merged, error) {
+	if p < MergeUnion || p > MergeDenyOverrides {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownMergePolicy, p)
+	}
+
+	sources := make([]policy, len(acls))
+	names := make([]string, len(acls))
+	for i, a := range acls {
+		if a == nil {
+			return nil, fmt.Errorf("%w: source %d", ErrNilAcl, i)
+		}
+		sources[i] = a.policy()
+		names[i] = labelOr(a.Name(), fmt.Sprintf("#%d", i))
+	}
+
+	doc := &aclJSON{
+		Version: FormatVersion,
+		Roles:   mergeRoles(p, sources),
+		Scopes:  make(map[string]scopeJSON),
+		Groups:  mergeGroups(p, sources),
+	}
+	provenance := make(map[string][]Contribution)
+
+	contributors := make(map[string][]int)
+	all := make(map[string]struct{})
+	for _, src := range sources {
+		for sc := range src.rules {
+			all[sc] = emptyStruct
+		}
+	}
+
+	for _, sc := range sortedNames(all) {
+		holders := []int{}
+		for i, src := range sources {
+			if _, ok := src.rules[sc]; ok {
+				holders = append(holders, i)
+			}
+		}
+
+		switch {
+		case p == MergeIntersection && len(holders) != len(sources):
+			continue
+		case p == MergeLastWriterWins:
+			holders = holders[len(holders)-1:]
+		}
+
+		doc.Scopes[sc] = mergeScope(p, sc, sources, holders)
+		contributors[sc] = holders
+	}
+
+	a, err := doc.toAcl()
+	if err != nil {
+		return nil, err
+	}
+
+	for sc, holders := range contributors {
+		merged := doc.Scopes[sc]
+		granted := merged.Grant
+		for _, t := range merged.Temporary {
+			granted |= t.Permissions
+		}
+		for _, name := range merged.Roles {
+			granted |= a.roleMask(name)
+		}
+
+		for _, i := range holders {
+			if contributed := grantedBy(sources[i], sc) & granted; contributed != permission.None {
+				provenance[sc] = append(provenance[sc], Contribution{Source: names[i], Permission: contributed})
+			}
+		}
+	}
+
+	return &Merged{Acl: a, Provenance: provenance}, nil
+}
+
+// grantedBy returns the grants of sc in src, including
+// those that come through the roles assigned to it.
+func grantedBy(src policy, sc string) permission.Permission {
+	perms := heldBy(src, sc)
+	for _, name := range src.assigned[sc] {
+		perms |= src.roleMask(name)
+	}
+
+	return perms
+}
+
+// heldBy returns the grants, permanent or temporary, of sc in src.
+func heldBy(src policy, sc string) permission.Permission {
+	perms := src.rules[sc]
+	for bit := range src.expiries[sc] {
+		perms |= bit
+	}
+
+	return perms
+}
+
+// mergeScope merges the rules that the sources at holders have for sc.
+func mergeScope(p MergePolicy, sc string, sources []policy, holders []int) scopeJSON {
+	var grant, deny permission.Permission
+	expiries := make(map[permission.Permission]int64)
+	roles := make(map[string]int)
+
+	if p == MergeIntersection {
+		grant, deny = ^permission.None, permission.None
+		everywhere := ^permission.None
+		for _, i := range holders {
+			grant &= sources[i].rules[sc]
+			everywhere &= heldBy(sources[i], sc)
+		}
+
+		// Bits that some source only grants for a while expire
+		// when the first of those grants does.
+		for _, i := range holders {
+			for bit, until := range sources[i].expiries[sc] {
+				if bit&everywhere&^grant == 0 {
+					continue
+				}
+				if current, ok := expiries[bit]; !ok || until < current {
+					expiries[bit] = until
+				}
+			}
+		}
+	} else {
+		for _, i := range holders {
+			grant |= sources[i].rules[sc]
+			for bit, until := range sources[i].expiries[sc] {
+				if current, ok := expiries[bit]; !ok || until > current {
+					expiries[bit] = until
+				}
+			}
+		}
+		for bit := range expiries {
+			if bit&grant != 0 {
+				delete(expiries, bit)
+			}
+		}
+	}
+
+	for _, i := range holders {
+		deny |= sources[i].denies[sc]
+		for _, name := range sources[i].assigned[sc] {
+			roles[name]++
+		}
+	}
+
+	if p == MergeUnion {
+		granted := grant
+		for bit := range expiries {
+			granted |= bit
+		}
+		deny &^= permission.Closure(granted)
+	}
+
+	merged := scopeJSON{Grant: grant, Deny: deny}
+	for _, name := range sortedKeys(roles) {
+		if p != MergeIntersection || roles[name] == len(holders) {
+			merged.Roles = append(merged.Roles, name)
+		}
+	}
+
+	byExpiry := make(map[int64]permission.Permission)
+	for bit, until := range expiries {
+		byExpiry[until] |= bit
+	}
+	for until, perms := range byExpiry {
+		merged.Temporary = append(merged.Temporary, temporaryJSON{Permissions: perms, Until: time.Unix(until, 0).UTC()})
+	}
+	sort.Slice(merged.Temporary, func(i, j int) bool { return merged.Temporary[i].Until.Before(merged.Temporary[j].Until) })
+
+	return merged
+}
+
+func mergeRoles(p MergePolicy, sources []policy) map[string]roleJSON {
+	defined := make(map[string][]rolePolicy)
+	for _, src := range sources {
+		for name, r := range src.roles {
+			defined[name] = append(defined[name], r)
+		}
+	}
+
+	roles := make(map[string]roleJSON, len(defined))
+	for name, definitions := range defined {
+		switch p {
+		case MergeIntersection:
+			if len(definitions) != len(sources) {
+				continue
+			}
+			perms, counts := ^permission.None, make(map[string]int)
+			for _, r := range definitions {
+				perms &= r.perms
+				for _, included := range r.includes {
+					counts[included]++
+				}
+			}
+			includes := []string{}
+			for _, included := range sortedKeys(counts) {
+				if counts[included] == len(definitions) {
+					includes = append(includes, included)
+				}
+			}
+			roles[name] = roleJSON{Permissions: perms, Includes: includes}
+		case MergeLastWriterWins:
+			last := definitions[len(definitions)-1]
+			roles[name] = roleJSON{Permissions: last.perms, Includes: last.includes}
+		default:
+			perms, includes := permission.None, make(map[string]struct{})
+			for _, r := range definitions {
+				perms |= r.perms
+				for _, included := range r.includes {
+					includes[included] = emptyStruct
+				}
+			}
+			roles[name] = roleJSON{Permissions: perms, Includes: sortedNames(includes)}
+		}
+	}
+
+	return roles
+}
+
+func mergeGroups(p MergePolicy, sources []policy) map[string][]string {
+	counts := make(map[string]map[string]int)
+	last := make(map[string][]string)
+	for _, src := range sources {
+		for group, members := range src.members {
+			if counts[group] == nil {
+				counts[group] = make(map[string]int)
+			}
+			for _, member := range members {
+				counts[group][member]++
+			}
+			last[group] = members
+		}
+	}
+
+	if p == MergeLastWriterWins {
+		return last
+	}
+
+	groups := make(map[string][]string, len(counts))
+	for group, members := range counts {
+		for _, member := range sortedKeys(members) {
+			if p != MergeIntersection || members[member] == len(sources) {
+				groups[group] = append(groups[group], member)
+			}
+		}
+	}
+
+	return groups
+}
@@ -0,0 +1,164 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+func mergeSources(t *testing.T) []*Acl {
+	t.Helper()
+
+	org, _ := Stoa("viewer=read\nalice-viewer\nbob-write\ncarol-read-!delete\nstaff+=alice,bob")
+	org.SetName("org")
+	team, _ := Stoa("alice-write\nbob-read|write-!execute\ncarol-delete\nstaff+=bob")
+	team.SetName("team")
+	project, _ := Stoa("viewer=list|read\nalice-execute\nbob-write\ndave-read")
+
+	return []*Acl{org, team, project}
+}
+
+func TestMergePolicies(t *testing.T) {
+	cases := []struct {
+		policy MergePolicy
+		want   string
+	}{
+		{
+			policy: MergeUnion,
+			want: Header + "\nviewer=list|read\n" +
+				"alice-write|execute|viewer\nbob-read|write-!execute\ncarol-read|delete\ndave-read\nstaff\n" +
+				"staff+=alice,bob",
+		},
+		{
+			policy: MergeIntersection,
+			want:   Header + "\nalice\nbob-write-!execute",
+		},
+		{
+			policy: MergeLastWriterWins,
+			want: Header + "\nviewer=list|read\n" +
+				"alice-execute\nbob-write\ncarol-delete\ndave-read\nstaff\n" +
+				"staff+=bob",
+		},
+		{
+			policy: MergeDenyOverrides,
+			want: Header + "\nviewer=list|read\n" +
+				"alice-write|execute|viewer\nbob-read|write-!execute\ncarol-read|delete-!delete\ndave-read\nstaff\n" +
+				"staff+=alice,bob",
+		},
+	}
+
+	for _, tc := range cases {
+		merged, err := Merge(tc.policy, mergeSources(t)...)
+		if err != nil {
+			t.Fatalf("policy %d: %v", tc.policy, err)
+		}
+		if got := merged.String(); got != tc.want {
+			t.Errorf("policy %d: wanted\n%s\ngot\n%s", tc.policy, tc.want, got)
+		}
+	}
+}
+
+func TestMergeProvenance(t *testing.T) {
+	merged, err := Merge(MergeUnion, mergeSources(t)...)
+	if err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+
+	want := []Contribution{
+		{Source: "org", Permission: permission.Write},
+		{Source: "team", Permission: permission.Read | permission.Write},
+		{Source: "#2", Permission: permission.Write},
+	}
+	if got := merged.Provenance["bob"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %+v got %+v", want, got)
+	}
+
+	want = []Contribution{{Source: "team", Permission: permission.Read}}
+	if got := merged.Sources("bob", permission.Read|permission.Execute); !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %+v got %+v", want, got)
+	}
+
+	// Grants that come through roles are attributed as well.
+	want = []Contribution{
+		{Source: "org", Permission: permission.Read},
+		{Source: "team", Permission: permission.Write},
+		{Source: "#2", Permission: permission.Execute},
+	}
+	if got := merged.Provenance["alice"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %+v got %+v", want, got)
+	}
+	want = []Contribution{{Source: "org", Permission: permission.Read}}
+	if got := merged.Sources("alice", permission.Read); !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %+v got %+v", want, got)
+	}
+
+	lww, _ := Merge(MergeLastWriterWins, mergeSources(t)...)
+	want = []Contribution{{Source: "team", Permission: permission.Delete}}
+	if got := lww.Provenance["carol"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %+v got %+v", want, got)
+	}
+}
+
+func TestMergeTemporaryGrants(t *testing.T) {
+	fc := newFakeClock()
+	early, late := fc.Now().Add(time.Hour), fc.Now().Add(2*time.Hour)
+
+	a := &Acl{}
+	a.SetClock(fc.Now)
+	a.RegisterUser("alice")
+	a.InsertUntil("alice", early, permission.Read)
+	a.Insert("alice", permission.Write)
+
+	b := &Acl{}
+	b.SetClock(fc.Now)
+	b.RegisterUser("alice")
+	b.InsertUntil("alice", late, permission.Read|permission.Write)
+
+	union, _ := Merge(MergeUnion, a, b)
+	if got, want := union.String(), Header+"\nalice-write-read@1700007200"; got != want {
+		t.Errorf("union: wanted %q got %q", want, got)
+	}
+
+	// Every bit expires with the earliest temporary grant of it.
+	intersection, _ := Merge(MergeIntersection, a, b)
+	if got, want := intersection.String(), Header+"\nalice-read@1700003600-write@1700007200"; got != want {
+		t.Errorf("intersection: wanted %q got %q", want, got)
+	}
+}
+
+func TestMergeErrors(t *testing.T) {
+	if _, err := Merge(MergePolicy(42)); !errors.Is(err, ErrUnknownMergePolicy) {
+		t.Errorf("expected ErrUnknownMergePolicy, got %v", err)
+	}
+	if _, err := Merge(MergeUnion, mergeSources(t)[0], nil); !errors.Is(err, ErrNilAcl) {
+		t.Errorf("expected ErrNilAcl, got %v", err)
+	}
+
+	a, _ := Stoa("g+=h")
+	b, _ := Stoa("h+=g")
+	if _, err := Merge(MergeUnion, a, b); !errors.Is(err, ErrMembershipCycle) {
+		t.Errorf("expected ErrMembershipCycle, got %v", err)
+	}
+
+	merged, err := Merge(MergeUnion)
+	if err != nil || merged.String() != Header {
+		t.Errorf("expected an empty acl from no sources, got %q %v", merged, err)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+var (
+	ErrUnknownOp = errors.New("unknown operation")
+	// ErrNotReplayable is returned for an OpReplace, after which
+	// the rules have to be copied over through Versioned instead.
+	ErrNotReplayable = errors.New("mutation cannot be replayed")
+)
+
+// Mutate applies m, typically one received through Watch or read back
+// from storage, so that replaying the mutations of one Acl onto another
+// reproduces its rules. Permanent inserts are replayed as such whatever
+// the TTL, and temporary grants that have since expired are skipped.
+func (a *Acl) Mutate(m Mutation) (err error) {
+	perms := []permission.Permission{m.Permissions}
+
+	switch m.Op {
+	case OpRegisterUser:
+		return a.registerUser("", m.Scope)
+	case OpDeRegisterUser:
+		return a.deRegisterUser("", m.Scope)
+	case OpInsert:
+		if m.Expiry.IsZero() {
+			_, err = a.insert("", m.Scope, perms, false)
+			return
+		}
+		_, err = a.insertUntilFor("", m.Scope, m.Expiry, perms)
+		if errors.Is(err, ErrAlreadyExpired) {
+			err = nil
+		}
+		return
+	case OpRemove:
+		_, _, err = a.remove("", m.Scope, perms)
+		return
+	case OpDeny:
+		_, err = a.deny("", m.Scope, perms)
+		return
+	case OpUndeny:
+		_, _, err = a.undeny("", m.Scope, perms)
+		return
+	case OpSweep:
+		return a.sweepScope("", m.Scope, m.Permissions)
+	case OpAddMember:
+		return a.addMemberAs("", m.Scope, m.Subject)
+	case OpRemoveMember:
+		return a.removeMember("", m.Scope, m.Subject)
+	case OpDefineRole:
+		return a.defineRoleAs("", m.Subject, m.Permissions, m.Includes)
+	case OpUndefineRole:
+		return a.undefineRole("", m.Subject)
+	case OpAssignRole:
+		return a.assignRoleAs("", m.Scope, m.Subject)
+	case OpUnassignRole:
+		return a.unassignRole("", m.Scope, m.Subject)
+	case OpSetTTL:
+		a.SetTTL(m.TTL)
+		return nil
+	case OpReplace:
+		return fmt.Errorf("%w: %v", ErrNotReplayable, m.Op)
+	default:
+		return fmt.Errorf("%w: %v", ErrUnknownOp, m.Op)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+func TestMutateReplaysChanges(t *testing.T) {
+	fc := newFakeClock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := Acl{}
+	src.SetClock(fc.Now)
+	changes := src.Watch(ctx)
+
+	src.RegisterUser("ops")
+	src.RegisterUser("henry")
+	src.DefineRole("viewer", permission.Read)
+	src.DefineRole("editor", permission.Write, "viewer")
+	src.AssignRole("ops", "editor")
+	src.AddMember("ops", "henry")
+	src.Insert("henry", permission.Execute|permission.List)
+	src.InsertFor("henry", time.Hour, permission.Delete)
+	src.Deny("henry", permission.List)
+	src.Remove("henry", permission.List, permission.Execute)
+	src.SetTTL(time.Minute)
+	src.Insert("ops", permission.Execute)
+
+	dst := Acl{}
+	dst.SetClock(fc.Now)
+	for {
+		select {
+		case change := <-changes:
+			if err := dst.Mutate(change.Mutation); err != nil {
+				t.Fatalf("seq %d: %v", change.Seq, err)
+			}
+			continue
+		default:
+		}
+		break
+	}
+
+	if got, want := dst.String(), src.String(); got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+	if got := dst.TTL(); got != time.Minute {
+		t.Errorf("expected the TTL to be replayed, got %v", got)
+	}
+
+	if err := dst.Mutate(Mutation{Op: Op(-1)}); err == nil {
+		t.Errorf("expected an error for an unknown op")
+	}
+}
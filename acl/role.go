@@ -0,0 +1,374 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/odeke-em/acl/common"
+	"github.com/odeke-em/acl/permission"
+	"github.com/odeke-em/acl/scope"
+)
+
+var (
+	ErrInvalidRoleName  = errors.New("invalid role name")
+	ErrRoleDoesnotExist = errors.New("role does not exist")
+	ErrRoleCycle        = errors.New("role cycle")
+	ErrRoleInUse        = errors.New("role in use")
+	ErrRoleNotAssigned  = errors.New("role not assigned")
+)
+
+// role is a named bundle of permissions and other roles.
+type role struct {
+	perms    permission.Permission
+	includes map[string]struct{}
+}
+
+// DefineRole defines or redefines the role name as perms together with
+// every permission of the roles it includes, which have to be defined
+// already. Redefining a role changes access for all of its holders at
+// once. Role names follow permission.ValidName and may not clash with
+// registered permission names. In text form a role is defined as
+// "editor=viewer|write".
+func (a *Acl) DefineRole(name string, perms permission.Permission, includes ...string) error {
+	return a.defineRoleAs("", name, perms, includes)
+}
+
+func (a *Acl) defineRoleAs(actor, name string, perms permission.Permission, includes []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.defineRoleLocked(actor, name, perms, includes)
+}
+
+// defineRoleLocked defines the role name and emits the change.
+// It has to be called with a.mu held.
+func (a *Acl) defineRoleLocked(actor, name string, perms permission.Permission, includes []string) (err error) {
+	ev := event(actor, OpDefineRole, "")
+	ev.Subject, ev.Before = name, a.roleMask(name)
+	ev.perms, ev.includes = perms, includes
+	defer func() {
+		ev.After = a.roleMask(name)
+		a.emit(ev, err)
+	}()
+
+	return a.defineRole(name, perms, includes)
+}
+
+func (a *Acl) defineRole(name string, perms permission.Permission, includes []string) error {
+	if !permission.ValidName(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidRoleName, name)
+	}
+	if _, ok := permission.DefaultRegistry.Lookup(name); ok {
+		return fmt.Errorf("%w: %q is a permission", ErrInvalidRoleName, name)
+	}
+
+	r := &role{perms: perms, includes: make(map[string]struct{})}
+	for _, included := range includes {
+		if _, ok := a.roles[included]; !ok {
+			return fmt.Errorf("%w: %q", ErrRoleDoesnotExist, included)
+		}
+		if included == name || a.includesRole(included, name) {
+			return fmt.Errorf("%w: %q includes %q", ErrRoleCycle, included, name)
+		}
+		r.includes[included] = emptyStruct
+	}
+
+	if a.roles == nil {
+		a.roles = make(map[string]*role)
+	}
+	a.roles[name] = r
+	return nil
+}
+
+// UndefineRole removes the role name, provided that it is
+// neither assigned to a scope nor included by another role.
+func (a *Acl) UndefineRole(name string) error {
+	return a.undefineRole("", name)
+}
+
+func (a *Acl) undefineRole(actor, name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.undefineRoleLocked(actor, name)
+}
+
+// undefineRoleLocked removes the role name and emits the change.
+// It has to be called with a.mu held.
+func (a *Acl) undefineRoleLocked(actor, name string) (err error) {
+	ev := event(actor, OpUndefineRole, "")
+	ev.Subject, ev.Before = name, a.roleMask(name)
+	defer func() {
+		ev.After = a.roleMask(name)
+		a.emit(ev, err)
+	}()
+
+	if err := a.undefinable(name); err != nil {
+		return err
+	}
+
+	delete(a.roles, name)
+	return nil
+}
+
+// undefinable reports why the role name cannot be undefined, if it
+// cannot. It has to be called with a.mu held.
+func (a *Acl) undefinable(name string) error {
+	if _, ok := a.roles[name]; !ok {
+		return fmt.Errorf("%w: %q", ErrRoleDoesnotExist, name)
+	}
+
+	for other, r := range a.roles {
+		if _, ok := r.includes[name]; ok {
+			return fmt.Errorf("%w: included by %q", ErrRoleInUse, other)
+		}
+	}
+	for sc, names := range a.assigned {
+		if _, ok := names[name]; ok {
+			return fmt.Errorf("%w: assigned to %q", ErrRoleInUse, sc)
+		}
+	}
+
+	return nil
+}
+
+// AssignRole grants userId every permission of the role name.
+func (a *Acl) AssignRole(userId, name string) error {
+	return a.assignRoleAs("", userId, name)
+}
+
+func (a *Acl) assignRoleAs(actor, userId, name string) (err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ev := event(actor, OpAssignRole, userId)
+	ev.Subject = name
+	defer func() { a.emit(ev, err) }()
+
+	sc, err := a.registered(userId)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := a.roles[name]; !ok {
+		return fmt.Errorf("%w: %q", ErrRoleDoesnotExist, name)
+	}
+
+	a.assignRole(sc, name)
+	return nil
+}
+
+// UnassignRole takes the role name away from userId.
+func (a *Acl) UnassignRole(userId, name string) error {
+	return a.unassignRole("", userId, name)
+}
+
+func (a *Acl) unassignRole(actor, userId, name string) (err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ev := event(actor, OpUnassignRole, userId)
+	ev.Subject = name
+	defer func() { a.emit(ev, err) }()
+
+	sc, err := a.registered(userId)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := a.roles[name]; !ok {
+		return fmt.Errorf("%w: %q", ErrRoleDoesnotExist, name)
+	}
+	if _, ok := a.assigned[sc][name]; !ok {
+		return fmt.Errorf("%w: %q is not assigned to %q", ErrRoleNotAssigned, name, userId)
+	}
+
+	delete(a.assigned[sc], name)
+	if len(a.assigned[sc]) == 0 {
+		delete(a.assigned, sc)
+	}
+	return nil
+}
+
+func (a *Acl) registered(userId string) (sc scope.Scope, err error) {
+	if a.rules == nil {
+		err = ErrUninitializedACL
+		return
+	}
+
+	if sc, err = scope.New(userId); err != nil {
+		return
+	}
+
+	if _, ok := a.rules[sc]; !ok {
+		err = fmt.Errorf("%w: %q", ErrUserDoesnotExist, userId)
+	}
+	return
+}
+
+func (a *Acl) assignRole(sc scope.Scope, name string) {
+	if a.assigned == nil {
+		a.assigned = make(map[scope.Scope]map[string]struct{})
+	}
+
+	names, ok := a.assigned[sc]
+	if !ok {
+		names = make(map[string]struct{})
+		a.assigned[sc] = names
+	}
+	names[name] = emptyStruct
+}
+
+// includesRole reports whether the role name includes target, transitively.
+func (a *Acl) includesRole(name, target string) bool {
+	r, ok := a.roles[name]
+	if !ok {
+		return false
+	}
+
+	for included := range r.includes {
+		if included == target || a.includesRole(included, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// roleMask returns every permission granted by the role name.
+func (a *Acl) roleMask(name string) permission.Permission {
+	r, ok := a.roles[name]
+	if !ok {
+		return permission.None
+	}
+
+	mask := r.perms
+	for included := range r.includes {
+		mask |= a.roleMask(included)
+	}
+
+	return mask
+}
+
+func isRoleDefinition(rule string) bool {
+	return indexUnescaped(rule, roleDelimiter) >= 0 && indexUnescaped(rule, membershipDelimiter) < 0
+}
+
+// parseRoleDefinitions parses "name=permissions|roles" rules, which
+// may reference each other in any order.
+func (a *Acl) parseRoleDefinitions(rules []string) (err error) {
+	pending := make(map[string]string)
+	for _, rule := range rules {
+		i := strings.Index(rule, roleDelimiter)
+		name := strings.Trim(rule[:i], " ")
+		pending[name] = rule[i+len(roleDelimiter):]
+	}
+
+	names := make([]string, 0, len(pending))
+	for name := range pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	visiting := make(map[string]bool)
+	var define func(name string) error
+	define = func(name string) error {
+		body, ok := pending[name]
+		if !ok {
+			return nil
+		}
+		if visiting[name] {
+			return fmt.Errorf("%w: %q", ErrRoleCycle, name)
+		}
+		visiting[name] = true
+
+		for _, token := range strings.Split(body, permission.Separator) {
+			if dErr := define(strings.Trim(token, " ")); dErr != nil {
+				return dErr
+			}
+		}
+
+		permStr, includes := a.extractRoles(body)
+
+		perms, pErr := permission.Atop(permStr)
+		if pErr != nil {
+			return fmt.Errorf("role: %q err: %s", name, pErr.Error())
+		}
+
+		delete(pending, name)
+		return a.defineRole(name, perms, includes)
+	}
+
+	for _, name := range names {
+		if dErr := define(name); dErr != nil {
+			err = common.ReComposeError(err, dErr.Error())
+		}
+	}
+
+	return
+}
+
+// extractRoles removes the names of defined roles from permStr
+// and returns them separately.
+func (a *Acl) extractRoles(permStr string) (rest string, roles []string) {
+	kept := []string{}
+	for _, token := range strings.Split(permStr, permission.Separator) {
+		trimmed := strings.Trim(token, " ")
+		if _, ok := a.roles[trimmed]; ok {
+			roles = append(roles, trimmed)
+			continue
+		}
+		kept = append(kept, token)
+	}
+
+	return strings.Join(kept, permission.Separator), roles
+}
+
+// formatGrant returns perms followed by the sorted names of roles.
+func (a *Acl) formatGrant(perms permission.Permission, roles map[string]struct{}) string {
+	sects := []string{}
+	if perms != permission.None {
+		sects = append(sects, perms.String())
+	}
+
+	names := make([]string, 0, len(roles))
+	for name := range roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return strings.Join(append(sects, names...), permission.Separator)
+}
+
+// formatRoles returns the text form of every role definition, ordered by name.
+func (a *Acl) formatRoles(transform func(permission.Permission) permission.Permission) []string {
+	names := make([]string, 0, len(a.roles))
+	for name := range a.roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rules := make([]string, 0, len(names))
+	for _, name := range names {
+		r := a.roles[name]
+		rules = append(rules, name+roleDelimiter+a.formatGrant(transform(r.perms), r.includes))
+	}
+
+	return rules
+}
@@ -0,0 +1,111 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+func TestStoaRoles(t *testing.T) {
+	acl, err := Stoa("alice-editor:bob-viewer|execute\nadmin=editor|delete:editor = viewer|write\nviewer = list|read")
+	if err != nil {
+		t.Fatalf("expected a successful parse, got %v", err)
+	}
+
+	cases := []struct {
+		user string
+		perm permission.Permission
+		want bool
+	}{
+		{user: "alice", perm: permission.Write | permission.Read, want: true},
+		{user: "alice", perm: permission.Delete, want: false},
+		{user: "bob", perm: permission.Read | permission.Execute, want: true},
+		{user: "bob", perm: permission.Write, want: false},
+	}
+
+	for _, tc := range cases {
+		wasSet, _, err := acl.Check(tc.user, tc.perm)
+		if err != nil {
+			t.Errorf("%s %q: unexpected err %v", tc.user, tc.perm, err)
+		}
+
+		if got := len(wasSet) == 1; got != tc.want {
+			t.Errorf("%s %q: wanted %v got %v", tc.user, tc.perm, tc.want, got)
+		}
+	}
+
+	want := Header + "\nadmin=delete|editor\neditor=write|viewer\nviewer=list|read\nalice-editor\nbob-execute|viewer"
+	if got := acl.String(); got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+}
+
+func TestRedefiningRolesAffectsHolders(t *testing.T) {
+	acl := Acl{}
+	uid := "carol"
+	if err := acl.RegisterUser(uid); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	if err := acl.DefineRole("viewer", permission.List); err != nil {
+		t.Fatalf("define viewer: %v", err)
+	}
+	if err := acl.DefineRole("editor", permission.Write, "viewer"); err != nil {
+		t.Fatalf("define editor: %v", err)
+	}
+	if err := acl.AssignRole(uid, "editor"); err != nil {
+		t.Fatalf("assign: %v", err)
+	}
+
+	if wasSet, _, _ := acl.Check(uid, permission.Execute); len(wasSet) != 0 {
+		t.Errorf("did not expect execute to be granted yet")
+	}
+
+	if err := acl.DefineRole("viewer", permission.Execute); err != nil {
+		t.Fatalf("redefine viewer: %v", err)
+	}
+	if wasSet, _, _ := acl.Check(uid, permission.Execute); len(wasSet) != 1 {
+		t.Errorf("expected execute to be granted through editor")
+	}
+
+	if err := acl.DefineRole("viewer", permission.List, "editor"); !errors.Is(err, ErrRoleCycle) {
+		t.Errorf("expected ErrRoleCycle, got %v", err)
+	}
+	if err := acl.DefineRole("read", permission.List); !errors.Is(err, ErrInvalidRoleName) {
+		t.Errorf("expected ErrInvalidRoleName, got %v", err)
+	}
+	if err := acl.UndefineRole("viewer"); !errors.Is(err, ErrRoleInUse) {
+		t.Errorf("expected ErrRoleInUse, got %v", err)
+	}
+
+	if err := acl.UnassignRole(uid, "editor"); err != nil {
+		t.Errorf("unassign: %v", err)
+	}
+	if err := acl.UnassignRole(uid, "editor"); !errors.Is(err, ErrRoleNotAssigned) {
+		t.Errorf("expected ErrRoleNotAssigned, got %v", err)
+	}
+	if err := acl.UnassignRole(uid, "auditor"); !errors.Is(err, ErrRoleDoesnotExist) {
+		t.Errorf("expected ErrRoleDoesnotExist, got %v", err)
+	}
+	if err := acl.UndefineRole("editor"); err != nil {
+		t.Errorf("undefine editor: %v", err)
+	}
+	if wasSet, _, _ := acl.Check(uid, permission.Write); len(wasSet) != 0 {
+		t.Errorf("did not expect write once editor was unassigned")
+	}
+}
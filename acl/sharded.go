@@ -0,0 +1,337 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/odeke-em/acl/audit"
+	"github.com/odeke-em/acl/common"
+	"github.com/odeke-em/acl/permission"
+	"github.com/odeke-em/acl/scope"
+)
+
+var ErrShardedMembership = errors.New("sharded acls do not support group memberships")
+
+// Sharded spreads scopes across independently locked Acls, by the hash
+// of the scope, so that writes to different scopes do not contend.
+// Roles are defined in every shard and can be assigned as usual, but
+// groups are not supported since a group and its members would live in
+// different shards.
+//
+// Operations on a single scope lock only its shard. Operations over
+// every shard either lock the shards one at a time or, to see a
+// consistent state, all of them in ascending order, which keeps them
+// from deadlocking with each other. Changes to roles, the clock, the
+// TTL and the auditor lock every shard, and checks wait for them to
+// finish, so that no two checks see different roles or clocks.
+type Sharded struct {
+	shards []*Acl
+	// seq is odd while a change to every shard is under way.
+	seq atomic.Uint64
+}
+
+// NewSharded returns an empty Sharded with n shards, or one if n < 1.
+func NewSharded(n int) *Sharded {
+	if n < 1 {
+		n = 1
+	}
+
+	s := &Sharded{shards: make([]*Acl, n)}
+	for i := range s.shards {
+		s.shards[i], _ = Stoa("")
+	}
+	return s
+}
+
+// StoaSharded parses s, as Stoa would, into a Sharded with n shards.
+// It fails with ErrShardedMembership for group memberships.
+func StoaSharded(n int, s string) (sharded *Sharded, err error) {
+	sharded = NewSharded(n)
+
+	definitions, items, err := splitRules(s)
+	if err != nil {
+		return
+	}
+
+	for _, shard := range sharded.shards {
+		if defErr := shard.parseRoleDefinitions(definitions); defErr != nil {
+			err = common.ReComposeError(err, defErr.Error())
+			break
+		}
+	}
+
+	for _, item := range items {
+		if indexUnescaped(item, membershipDelimiter) >= 0 {
+			err = common.ReComposeError(err, fmt.Sprintf("%q: %s", item, ErrShardedMembership))
+			continue
+		}
+
+		scopeStr := unescapeName(splitUnescaped(item, permissionDelimiter)[0])
+		if rErr := sharded.shard(scopeStr).parseRule(item); rErr != nil {
+			err = common.ReComposeError(err, rErr.Error())
+		}
+	}
+
+	return
+}
+
+// shard returns the shard that holds userId.
+func (s *Sharded) shard(userId string) *Acl {
+	sc, err := scope.New(userId)
+	if err != nil {
+		// Any shard will report the error.
+		return s.shards[0]
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(sc.String()))
+	return s.shards[h.Sum64()%uint64(len(s.shards))]
+}
+
+func (s *Sharded) RegisterUser(userId string) error {
+	return s.shard(userId).RegisterUser(userId)
+}
+
+func (s *Sharded) DeRegisterUser(userId string) error {
+	return s.shard(userId).DeRegisterUser(userId)
+}
+
+func (s *Sharded) Insert(userId string, permissions ...permission.Permission) ([]permission.Permission, error) {
+	return s.shard(userId).Insert(userId, permissions...)
+}
+
+func (s *Sharded) Remove(userId string, permissions ...permission.Permission) (pass, fail []permission.Permission, err error) {
+	return s.shard(userId).Remove(userId, permissions...)
+}
+
+func (s *Sharded) Deny(userId string, permissions ...permission.Permission) ([]permission.Permission, error) {
+	return s.shard(userId).Deny(userId, permissions...)
+}
+
+func (s *Sharded) Undeny(userId string, permissions ...permission.Permission) (pass, fail []permission.Permission, err error) {
+	return s.shard(userId).Undeny(userId, permissions...)
+}
+
+func (s *Sharded) Check(userId string, permissions ...permission.Permission) (wasSet, notSet []permission.Permission, err error) {
+	err = checkSnapshot(s.snapshot(userId), "", userId, permissions, &wasSet, &notSet, &notSet)
+	return
+}
+
+func (s *Sharded) CheckDenied(userId string, permissions ...permission.Permission) (wasSet, denied, notSet []permission.Permission, err error) {
+	err = checkSnapshot(s.snapshot(userId), "", userId, permissions, &wasSet, &denied, &notSet)
+	return
+}
+
+// snapshot returns the snapshot of the shard that holds userId, taken
+// while no change to every shard is under way.
+func (s *Sharded) snapshot(userId string) *snapshot {
+	shard := s.shard(userId)
+	for {
+		seq := s.seq.Load()
+		if seq%2 == 0 {
+			snap := shard.snapshot()
+			if s.seq.Load() == seq {
+				return snap
+			}
+		}
+
+		// The change holds every shard until it is done.
+		shard.mu.Lock()
+		shard.mu.Unlock()
+	}
+}
+
+// DefineRole defines the role name in every shard, as Acl.DefineRole does.
+func (s *Sharded) DefineRole(name string, perms permission.Permission, includes ...string) (err error) {
+	s.exclusively(func() {
+		// Every shard holds the same roles, so only the first can fail.
+		for _, shard := range s.shards {
+			if err = shard.defineRoleLocked("", name, perms, includes); err != nil {
+				return
+			}
+		}
+	})
+	return
+}
+
+// UndefineRole removes the role name from every shard, provided that
+// no shard has it assigned or included by another role.
+func (s *Sharded) UndefineRole(name string) (err error) {
+	s.exclusively(func() {
+		for _, shard := range s.shards {
+			if err = shard.undefinable(name); err != nil {
+				return
+			}
+		}
+		for _, shard := range s.shards {
+			shard.undefineRoleLocked("", name)
+		}
+	})
+	return
+}
+
+func (s *Sharded) AssignRole(userId, name string) error {
+	return s.shard(userId).AssignRole(userId, name)
+}
+
+func (s *Sharded) UnassignRole(userId, name string) error {
+	return s.shard(userId).UnassignRole(userId, name)
+}
+
+// SetClock replaces the clock of every shard, as Acl.SetClock does.
+func (s *Sharded) SetClock(now func() time.Time) {
+	s.exclusively(func() {
+		for _, shard := range s.shards {
+			shard.setClockLocked(now)
+		}
+	})
+}
+
+// SetTTL sets the TTL of every shard, as Acl.SetTTL does.
+func (s *Sharded) SetTTL(d time.Duration) {
+	s.exclusively(func() {
+		for _, shard := range s.shards {
+			shard.setTTLLocked(d)
+		}
+	})
+}
+
+// TTL returns the lifetime given to grants made through Insert.
+func (s *Sharded) TTL() time.Duration {
+	return s.shards[0].TTL()
+}
+
+// SetAuditor makes every shard report to auditor.
+func (s *Sharded) SetAuditor(auditor audit.Auditor) {
+	s.exclusively(func() {
+		for _, shard := range s.shards {
+			shard.setAuditorLocked(auditor)
+		}
+	})
+}
+
+// exclusively runs fn holding every shard, with checks waiting
+// until it is done, for changes that every shard has to make.
+func (s *Sharded) exclusively(fn func()) {
+	s.lockAll()
+	defer s.unlockAll()
+
+	s.seq.Add(1)
+	defer s.seq.Add(1)
+
+	fn()
+}
+
+// Len returns the number of registered scopes.
+func (s *Sharded) Len() (n int) {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		n += len(shard.rules)
+		shard.mu.Unlock()
+	}
+
+	return
+}
+
+// Range calls fn with every registered scope and the permissions that
+// it currently holds directly, shard by shard and in scope order within
+// a shard, until fn returns false. Each shard is copied under its lock
+// before fn sees it, so fn may call back into s.
+func (s *Sharded) Range(fn func(userId string, held permission.Permission) bool) {
+	for _, shard := range s.shards {
+		for _, line := range shard.heldLines() {
+			if !fn(line.scope, line.held) {
+				return
+			}
+		}
+	}
+}
+
+// Snapshot returns a single Acl holding a consistent copy of the rules of every shard.
+func (s *Sharded) Snapshot() *Acl {
+	s.lockAll()
+	defer s.unlockAll()
+
+	flat, _ := Stoa("")
+	for name, r := range s.shards[0].roles {
+		if flat.roles == nil {
+			flat.roles = make(map[string]*role)
+		}
+		// Roles are replaced rather than changed, so they can be shared.
+		flat.roles[name] = r
+	}
+
+	for _, shard := range s.shards {
+		for sc, perms := range shard.rules {
+			flat.rules[sc] = perms
+		}
+		for sc, perms := range shard.denies {
+			flat.denies[sc] = perms
+		}
+		for sc, expiries := range shard.expiries {
+			for bit, until := range expiries {
+				flat.setExpiry(sc, bit, until)
+			}
+		}
+		for sc, names := range shard.assigned {
+			for name := range names {
+				flat.assignRole(sc, name)
+			}
+		}
+	}
+
+	return flat
+}
+
+// String returns the same text form that an Acl with the rules of
+// every shard would, reading all shards consistently.
+func (s *Sharded) String() string {
+	s.lockAll()
+	defer s.unlockAll()
+
+	all := append([]string{Header}, s.shards[0].formatRoles(identity)...)
+
+	lines := []scopeLine{}
+	for _, shard := range s.shards {
+		lines = append(lines, shard.formatScopes(identity)...)
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].scope < lines[j].scope })
+	for _, line := range lines {
+		all = append(all, line.text)
+	}
+
+	return strings.Join(all, ruleSeparator)
+}
+
+// lockAll locks every shard in ascending order, the only order in
+// which more than one shard is ever locked.
+func (s *Sharded) lockAll() {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+	}
+}
+
+func (s *Sharded) unlockAll() {
+	for i := len(s.shards) - 1; i >= 0; i-- {
+		s.shards[i].mu.Unlock()
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/odeke-em/acl/audit"
+	"github.com/odeke-em/acl/permission"
+)
+
+func TestShardedMatchesAcl(t *testing.T) {
+	sharded := NewSharded(8)
+	single := &Acl{}
+
+	for _, a := range []interface {
+		DefineRole(string, permission.Permission, ...string) error
+		RegisterUser(string) error
+		Insert(string, ...permission.Permission) ([]permission.Permission, error)
+		Deny(string, ...permission.Permission) ([]permission.Permission, error)
+		AssignRole(string, string) error
+	}{sharded, single} {
+		a.DefineRole("viewer", permission.Read)
+		for i := 0; i < 100; i++ {
+			uid := fmt.Sprintf("org:user%d", i)
+			a.RegisterUser(uid)
+			a.Insert(uid, permission.Permission(1)<<uint(1+i%5))
+			if i%7 == 0 {
+				a.AssignRole(uid, "viewer")
+			}
+			if i%11 == 0 {
+				a.Deny(uid, permission.Read)
+			}
+		}
+	}
+
+	if got, want := sharded.String(), single.String(); got != want {
+		t.Errorf("wanted\n%s\ngot\n%s", want, got)
+	}
+	if !sharded.Snapshot().Equal(single) {
+		t.Errorf("expected the snapshot to equal the single acl")
+	}
+	if got := sharded.Len(); got != 100 {
+		t.Errorf("expected 100 scopes, got %d", got)
+	}
+
+	for i := 0; i < 100; i++ {
+		uid := fmt.Sprintf("org:user%d", i)
+		for _, perm := range []permission.Permission{permission.Read, permission.Write, permission.Delete} {
+			want, _, _ := single.Check(uid, perm)
+			got, _, _ := sharded.Check(uid, perm)
+			if len(got) != len(want) {
+				t.Errorf("%s %v: wanted %v got %v", uid, perm, want, got)
+			}
+		}
+	}
+
+	for _, shard := range sharded.shards {
+		if len(shard.rules) == 0 {
+			t.Errorf("expected every shard to hold some scopes")
+		}
+	}
+}
+
+func TestStoaSharded(t *testing.T) {
+	text := Header + "\nviewer=read\nalice-viewer\nbob-write-!delete\nc\\-d-execute"
+
+	for _, n := range []int{0, 1, 3, 16} {
+		sharded, err := StoaSharded(n, text)
+		if err != nil {
+			t.Fatalf("%d shards: %v", n, err)
+		}
+		if got := sharded.String(); got != text {
+			t.Errorf("%d shards: wanted %q got %q", n, text, got)
+		}
+	}
+}
+
+func TestShardedRejectsMemberships(t *testing.T) {
+	_, err := StoaSharded(4, "alice-read\nstaff+=alice")
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), ErrShardedMembership.Error(); !strings.Contains(got, want) {
+		t.Errorf("expected %q to mention %q", got, want)
+	}
+}
+
+func TestShardedConcurrentOperations(t *testing.T) {
+	sharded := NewSharded(4)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				uid := fmt.Sprintf("w%d:user%d", w, i)
+				sharded.RegisterUser(uid)
+				sharded.Insert(uid, permission.Read)
+				sharded.Check(uid, permission.Read)
+			}
+		}(w)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			_ = sharded.String()
+			_ = sharded.Snapshot()
+			// Calling back into the Sharded from Range must not deadlock.
+			sharded.Range(func(userId string, held permission.Permission) bool {
+				sharded.Check(userId, permission.Read)
+				return true
+			})
+		}
+	}()
+	wg.Wait()
+
+	seen := 0
+	sharded.Range(func(userId string, held permission.Permission) bool {
+		if held != permission.Read {
+			t.Errorf("%s: expected read, got %v", userId, held)
+		}
+		seen++
+		return true
+	})
+	if seen != 800 {
+		t.Errorf("expected 800 scopes, got %d", seen)
+	}
+}
+
+func TestShardedRoleChangesWaitForEveryShard(t *testing.T) {
+	sharded := NewSharded(8)
+	sharded.DefineRole("viewer", permission.Read)
+
+	// The last shard is the last to take the new definition.
+	last := sharded.shards[len(sharded.shards)-1]
+	var uid string
+	for i := 0; uid == ""; i++ {
+		if candidate := fmt.Sprintf("user%d", i); sharded.shard(candidate) == last {
+			uid = candidate
+		}
+	}
+	sharded.RegisterUser(uid)
+	sharded.AssignRole(uid, "viewer")
+
+	// Checks do not take the lock, so the auditor can start one while
+	// the first shard reports the redefinition, and give it a moment to
+	// answer before the other shards take the new definition.
+	var once sync.Once
+	seen := make(chan bool, 1)
+	sharded.SetAuditor(audit.AuditorFunc(func(ev audit.Event) {
+		if ev.Op != OpDefineRole.String() {
+			return
+		}
+		once.Do(func() {
+			checked := make(chan struct{})
+			go func() {
+				wasSet, _, _ := sharded.Check(uid, permission.Execute)
+				seen <- len(wasSet) == 1
+				close(checked)
+			}()
+
+			select {
+			case <-checked:
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}))
+
+	if err := sharded.DefineRole("viewer", permission.Execute); err != nil {
+		t.Fatalf("define: %v", err)
+	}
+	if !<-seen {
+		t.Errorf("expected a check made during the redefinition to wait for it")
+	}
+}
+
+func TestShardedRolesClockAndTTL(t *testing.T) {
+	fc := newFakeClock()
+	sharded := NewSharded(4)
+	sharded.SetClock(fc.Now)
+	sharded.SetTTL(time.Minute)
+	if got := sharded.TTL(); got != time.Minute {
+		t.Errorf("expected a TTL of a minute, got %v", got)
+	}
+
+	sharded.DefineRole("viewer", permission.Read)
+	for i := 0; i < 8; i++ {
+		uid := fmt.Sprintf("user%d", i)
+		sharded.RegisterUser(uid)
+		sharded.Insert(uid, permission.Write)
+	}
+	sharded.AssignRole("user3", "viewer")
+
+	fc.Advance(2 * time.Minute)
+	if wasSet, _, _ := sharded.Check("user5", permission.Write); len(wasSet) != 0 {
+		t.Errorf("expected the grant to have expired with the TTL")
+	}
+
+	if err := sharded.UndefineRole("viewer"); !errors.Is(err, ErrRoleInUse) {
+		t.Errorf("expected ErrRoleInUse, got %v", err)
+	}
+	for _, shard := range sharded.shards {
+		if _, ok := shard.roles["viewer"]; !ok {
+			t.Errorf("expected a failed undefine to leave every shard alone")
+		}
+	}
+
+	if err := sharded.UnassignRole("user3", "viewer"); err != nil {
+		t.Fatalf("unassign: %v", err)
+	}
+	if err := sharded.UndefineRole("viewer"); err != nil {
+		t.Fatalf("undefine: %v", err)
+	}
+	for _, shard := range sharded.shards {
+		if _, ok := shard.roles["viewer"]; ok {
+			t.Errorf("expected the role to be gone from every shard")
+		}
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"time"
+
+	"github.com/odeke-em/acl/audit"
+	"github.com/odeke-em/acl/permission"
+	"github.com/odeke-em/acl/scope"
+)
+
+// snapshot is an immutable copy of what Check reads, resolved ahead of
+// time so that checks need neither the lock nor the group and role
+// graphs. Every mutation publishes a new snapshot that shares all but
+// the trie nodes leading to the scopes whose resolutions it changed.
+type snapshot struct {
+	// initialized is false for an Acl that never had rules.
+	initialized bool
+	scopes      trie
+	// generation is that of the implications the closures were
+	// worked out with; the snapshot is stale once it changes.
+	generation uint64
+	clock      func() time.Time
+	auditor    audit.Auditor
+}
+
+// resolution holds what a scope is granted and denied, directly,
+// through roles and through the groups that it belongs to.
+type resolution struct {
+	// granted is the permanent grant and closed its closure.
+	granted permission.Permission
+	closed  permission.Permission
+	denied  permission.Permission
+	// temporary lists the temporary grants, which are only
+	// live until their expiry.
+	temporary []expiringBit
+}
+
+type expiringBit struct {
+	bit   permission.Permission
+	until time.Time
+}
+
+// at returns what r grants and denies at now.
+func (r *resolution) at(now time.Time) (granted, denied permission.Permission) {
+	live := permission.None
+	for _, t := range r.temporary {
+		if t.until.After(now) {
+			live |= t.bit
+		}
+	}
+
+	if live&^r.granted == permission.None {
+		return r.closed, r.denied
+	}
+	return permission.Closure(r.granted | live), r.denied
+}
+
+// snapshot returns the current snapshot. An Acl that was built
+// directly rather than through its methods has none yet, and one that
+// was published before the latest implication was declared is stale;
+// in either case the check builds a new one.
+func (a *Acl) snapshot() *snapshot {
+	if snap := a.snap.Load(); snap.fresh() {
+		return snap
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if snap := a.snap.Load(); snap.fresh() {
+		return snap
+	}
+
+	snap := a.buildSnapshot()
+	a.snap.Store(snap)
+	return snap
+}
+
+func (a *Acl) buildSnapshot() *snapshot {
+	snap := &snapshot{
+		initialized: a.rules != nil,
+		generation:  permission.Generation(),
+		clock:       a.clock,
+		auditor:     a.auditor,
+	}
+
+	masks := make(map[string]permission.Permission, len(a.roles))
+	for name := range a.roles {
+		masks[name] = a.roleMask(name)
+	}
+
+	for sc := range a.rules {
+		snap.scopes = snap.scopes.with(sc, a.resolve(sc, func(name string) permission.Permission { return masks[name] }))
+	}
+
+	return snap
+}
+
+// fresh reports whether snap can be checked against as it is.
+func (snap *snapshot) fresh() bool {
+	return snap != nil && snap.generation == permission.Generation()
+}
+
+// advance publishes a copy of the current snapshot with the resolutions
+// of scopes worked out again, or a whole new snapshot if there is none
+// or it is stale. It has to be called with a.mu held, after the state
+// has changed.
+func (a *Acl) advance(scopes []scope.Scope) {
+	current := a.snap.Load()
+	if !current.fresh() {
+		a.snap.Store(a.buildSnapshot())
+		return
+	}
+
+	snap := *current
+	snap.initialized = a.rules != nil
+
+	for _, sc := range scopes {
+		if _, ok := a.rules[sc]; ok {
+			snap.scopes = snap.scopes.with(sc, a.resolve(sc, a.roleMask))
+		} else {
+			snap.scopes = snap.scopes.without(sc)
+		}
+	}
+
+	a.snap.Store(&snap)
+}
+
+// reconfigure publishes a copy of the current snapshot with the clock
+// and auditor of a. It has to be called with a.mu held.
+func (a *Acl) reconfigure() {
+	current := a.snap.Load()
+	if current == nil {
+		return
+	}
+
+	snap := *current
+	snap.clock, snap.auditor = a.clock, a.auditor
+	a.snap.Store(&snap)
+}
+
+// affected returns the scopes whose resolution ev could have changed:
+// the scope it names, or the holders of the role it names, along with
+// everyone who inherits from them through groups.
+// It has to be called with a.mu held.
+func (a *Acl) affected(ev *operation) []scope.Scope {
+	if ev.affected != nil {
+		return ev.affected
+	}
+
+	var roots []scope.Scope
+	switch ev.op {
+	case OpDefineRole, OpUndefineRole:
+		for sc, names := range a.assigned {
+			for name := range names {
+				if name == ev.Subject || a.includesRole(name, ev.Subject) {
+					roots = append(roots, sc)
+					break
+				}
+			}
+		}
+	default:
+		target := ev.Scope
+		if ev.op == OpAddMember || ev.op == OpRemoveMember {
+			target = ev.Subject
+		}
+		if sc, err := scope.New(target); err == nil {
+			roots = append(roots, sc)
+		}
+	}
+
+	return a.dependents(roots)
+}
+
+// dependents returns roots along with every scope that is,
+// transitively, a member of one of them.
+func (a *Acl) dependents(roots []scope.Scope) []scope.Scope {
+	all := append([]scope.Scope(nil), roots...)
+	seen := make(map[scope.Scope]struct{}, len(all))
+	for _, sc := range all {
+		seen[sc] = emptyStruct
+	}
+
+	for i := 0; i < len(all); i++ {
+		for member := range a.members[all[i]] {
+			if _, ok := seen[member]; !ok {
+				seen[member] = emptyStruct
+				all = append(all, member)
+			}
+		}
+	}
+
+	return all
+}
+
+// resolve works out the resolution of sc, using mask for the permissions
+// granted by each role. It has to be called with a.mu held.
+func (a *Acl) resolve(sc scope.Scope, mask func(string) permission.Permission) *resolution {
+	r := &resolution{}
+	for _, principal := range a.principals(sc) {
+		r.granted |= a.rules[principal]
+		for name := range a.assigned[principal] {
+			r.granted |= mask(name)
+		}
+		r.denied |= a.denies[principal]
+		for bit, until := range a.expiries[principal] {
+			r.temporary = append(r.temporary, expiringBit{bit: bit, until: until})
+		}
+	}
+	r.closed = permission.Closure(r.granted)
+
+	return r
+}
+
+func (snap *snapshot) now() time.Time {
+	if snap.clock == nil {
+		return time.Now()
+	}
+
+	return snap.clock()
+}
+
+// audit reports a check to the auditor of snap, if any, the way
+// emit does for checks made under the lock.
+func (snap *snapshot) audit(ev *operation, err error) {
+	if snap.auditor == nil {
+		return
+	}
+
+	ev.Time = snap.now()
+	if err != nil {
+		ev.Err = err.Error()
+	}
+	snap.auditor.Audit(ev.Event)
+}
+
+// lookup returns the resolution of userId.
+func (snap *snapshot) lookup(userId string) (*resolution, error) {
+	if !snap.initialized {
+		return nil, ErrUninitializedACL
+	}
+
+	sc, err := scope.New(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	r, ok := snap.scopes.get(sc)
+	if !ok {
+		return nil, ErrUserDoesnotExist
+	}
+	return r, nil
+}
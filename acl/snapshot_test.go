@@ -0,0 +1,269 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/odeke-em/acl/audit"
+	"github.com/odeke-em/acl/permission"
+	"github.com/odeke-em/acl/scope"
+)
+
+func TestCheckSeesEveryChange(t *testing.T) {
+	fc := newFakeClock()
+	acl := Acl{}
+	acl.SetClock(fc.Now)
+
+	held := func(uid string, perm permission.Permission) bool {
+		wasSet, _, err := acl.Check(uid, perm)
+		return err == nil && len(wasSet) == 1
+	}
+
+	steps := []struct {
+		name   string
+		change func()
+		uid    string
+		perm   permission.Permission
+		want   bool
+	}{
+		{name: "register", change: func() { acl.RegisterUser("alice") }, uid: "alice", perm: permission.Read},
+		{name: "insert", change: func() { acl.Insert("alice", permission.Write) }, uid: "alice", perm: permission.Read, want: true},
+		{name: "deny", change: func() { acl.Deny("alice", permission.Read) }, uid: "alice", perm: permission.Read},
+		{name: "undeny", change: func() { acl.Undeny("alice", permission.Read) }, uid: "alice", perm: permission.Read, want: true},
+		{name: "role", change: func() {
+			acl.DefineRole("runner", permission.Execute)
+			acl.AssignRole("alice", "runner")
+		}, uid: "alice", perm: permission.Execute, want: true},
+		{name: "redefine", change: func() { acl.DefineRole("runner", permission.List) }, uid: "alice", perm: permission.Execute},
+		{name: "group", change: func() {
+			acl.RegisterUser("staff")
+			acl.Insert("staff", permission.Execute)
+			acl.AddMember("staff", "alice")
+		}, uid: "alice", perm: permission.Execute, want: true},
+		{name: "temporary", change: func() { acl.InsertFor("alice", time.Minute, permission.Delete) }, uid: "alice", perm: permission.Delete, want: true},
+		{name: "clock", change: func() { fc.Advance(time.Hour) }, uid: "alice", perm: permission.Delete},
+		{name: "rewind", change: func() { acl.SetClock(newFakeClock().Now) }, uid: "alice", perm: permission.Delete, want: true},
+		{name: "unmarshal", change: func() { acl.UnmarshalText([]byte("alice-list")) }, uid: "alice", perm: permission.Write},
+	}
+
+	for _, step := range steps {
+		step.change()
+		if got := held(step.uid, step.perm); got != step.want {
+			t.Errorf("%s: %s %v: wanted %v got %v", step.name, step.uid, step.perm, step.want, got)
+		}
+	}
+}
+
+func TestCheckAuditsFromSnapshot(t *testing.T) {
+	acl, _ := Stoa("alice-read")
+
+	var checks atomic.Int64
+	acl.Check("alice", permission.Read)
+	acl.SetAuditor(audit.AuditorFunc(func(ev audit.Event) {
+		if ev.Op == OpCheck.String() {
+			checks.Add(1)
+		}
+	}))
+
+	acl.Check("alice", permission.Read, permission.Write)
+	acl.Check("nobody", permission.Read)
+	if got := checks.Load(); got != 3 {
+		t.Errorf("expected 3 audited checks, got %d", got)
+	}
+}
+
+func TestConcurrentChecksAndWrites(t *testing.T) {
+	acl := Acl{}
+	acl.RegisterUser("alice")
+	acl.Insert("alice", permission.Read)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				// Write is only ever inserted along with execute.
+				wasSet, _, err := acl.Check("alice", permission.Read, permission.Write|permission.Execute)
+				if err != nil || len(wasSet) == 0 || wasSet[0] != permission.Read {
+					t.Errorf("unexpected check result %v %v", wasSet, err)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 1000; i++ {
+		acl.Insert("alice", permission.Write|permission.Execute)
+		acl.Remove("alice", permission.Write|permission.Execute)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// resolutions flattens snap for comparison, ordering temporary grants.
+func resolutions(snap *snapshot) map[string]resolution {
+	flat := make(map[string]resolution)
+	snap.scopes.each(func(sc scope.Scope, r *resolution) {
+		copied := *r
+		copied.temporary = append([]expiringBit(nil), r.temporary...)
+		sort.Slice(copied.temporary, func(i, j int) bool {
+			ti, tj := copied.temporary[i], copied.temporary[j]
+			return ti.bit < tj.bit || ti.bit == tj.bit && ti.until.Before(tj.until)
+		})
+		flat[sc.String()] = copied
+	})
+	return flat
+}
+
+func TestSnapshotAdvancesLikeARebuild(t *testing.T) {
+	matches := func(q quickAcl) bool {
+		q.DefineRole("viewer", permission.Read)
+		for i, name := range quickNames {
+			switch i % 4 {
+			case 0:
+				q.DeRegisterUser(name)
+			case 1:
+				q.RemoveMember(quickNames[(i+1)%len(quickNames)], name)
+			case 2:
+				q.UnassignRole(name, "editor")
+			}
+		}
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		want, got := resolutions(q.buildSnapshot()), resolutions(q.snap.Load())
+		if !reflect.DeepEqual(want, got) {
+			t.Logf("rebuilt %v advanced %v", want, got)
+			return false
+		}
+		return true
+	}
+
+	if err := quick.Check(matches, &quick.Config{MaxCount: 300}); err != nil {
+		t.Error(err)
+	}
+}
+
+var sign, countersign = func() (permission.Permission, permission.Permission) {
+	sign, err := permission.Register("sign")
+	if err != nil {
+		panic(err)
+	}
+	countersign, err := permission.Register("countersign")
+	if err != nil {
+		panic(err)
+	}
+	return sign, countersign
+}()
+
+func TestCheckSeesLaterImplications(t *testing.T) {
+	acl, _ := Stoa("alice-sign")
+	if _, notSet, _ := acl.Check("alice", countersign); len(notSet) != 1 {
+		t.Fatalf("expected countersign not to be set before it is implied")
+	}
+
+	if err := permission.Imply(sign, countersign); err != nil {
+		t.Fatal(err)
+	}
+
+	if wasSet, _, err := acl.Check("alice", countersign); err != nil || len(wasSet) != 1 {
+		t.Errorf("expected countersign to be set once implied, got %v err %v", wasSet, err)
+	}
+}
+
+func benchmarkAcl(users int) (*Acl, []string) {
+	a := &Acl{}
+	a.DefineRole("viewer", permission.Read)
+	a.RegisterUser("staff")
+	a.Insert("staff", permission.List)
+
+	uids := make([]string, users)
+	for i := range uids {
+		uids[i] = fmt.Sprintf("user%d", i)
+		a.RegisterUser(uids[i])
+		a.Insert(uids[i], permission.Write)
+		a.AssignRole(uids[i], "viewer")
+		a.AddMember("staff", uids[i])
+	}
+
+	return a, uids
+}
+
+// Run with -cpu 1,2,4,8 to see how Check scales with GOMAXPROCS.
+func BenchmarkCheckParallel(b *testing.B) {
+	a, uids := benchmarkAcl(1000)
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			a.Check(uids[i%len(uids)], permission.Read|permission.List)
+			i++
+		}
+	})
+}
+
+// BenchmarkCheckMixed makes one write for every 1000 checks.
+func BenchmarkCheckMixed(b *testing.B) {
+	a, uids := benchmarkAcl(1000)
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			uid := uids[i%len(uids)]
+			if i%1000 == 0 {
+				a.Insert(uid, permission.Execute)
+			} else {
+				a.Check(uid, permission.Read|permission.List)
+			}
+			i++
+		}
+	})
+}
+
+// BenchmarkWriteScaling registers and grants users in an Acl that
+// already holds n of them; the cost per write should barely grow with n.
+func BenchmarkWriteScaling(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			a, _ := benchmarkAcl(n)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				uid := fmt.Sprintf("new%d", i)
+				a.RegisterUser(uid)
+				a.Insert(uid, permission.Read)
+			}
+		})
+	}
+}
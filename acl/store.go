@@ -0,0 +1,306 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+// Store holds one Acl per resource, such as a document,
+// a bucket or an endpoint. It is safe for concurrent use.
+//
+// Resources shaped like slash separated paths, e.g. "/projects/a/docs/x",
+// inherit the grants and denials of their ancestors "/projects/a/docs",
+// "/projects/a", "/projects" and "/", up to and including the first one
+// that blocks inheritance. Grants and denials are merged across the whole
+// chain and, as within a single Acl, a denial anywhere on the chain wins.
+type Store struct {
+	mu      sync.RWMutex
+	acls    map[string]*Acl
+	blocked map[string]struct{}
+	// detach stops relaying the changes of each Acl in acls.
+	detach    map[string]func()
+	observers storeObservers
+}
+
+// storeObservers is kept apart from Store.mu because it is
+// reached from within the Acls, with their own locks held.
+type storeObservers struct {
+	mu  sync.RWMutex
+	fns map[*storeObserver]struct{}
+}
+
+type storeObserver struct {
+	fn func(resource string, m *Mutation)
+}
+
+// Source records the permissions that a resource
+// contributed to a decision made by Store.Check.
+type Source struct {
+	Resource   string
+	Permission permission.Permission
+}
+
+func NewStore() *Store {
+	return &Store{acls: make(map[string]*Acl)}
+}
+
+// Acl returns the Acl protecting resource, creating an empty one
+// the first time that resource is asked for.
+func (s *Store) Acl(resource string) *Acl {
+	if aclV, ok := s.Lookup(resource); ok {
+		return aclV
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.acls == nil {
+		s.acls = make(map[string]*Acl)
+	}
+
+	aclV, ok := s.acls[resource]
+	if !ok {
+		aclV = &Acl{rules: make(rulesMap)}
+		s.attach(resource, aclV)
+		s.notify(resource, nil)
+	}
+
+	return aclV
+}
+
+// Lookup returns the Acl protecting resource, if there is one.
+func (s *Store) Lookup(resource string) (*Acl, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	aclV, ok := s.acls[resource]
+	return aclV, ok
+}
+
+// Set makes aclV the Acl protecting resource.
+func (s *Store) Set(resource string, aclV *Acl) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.acls == nil {
+		s.acls = make(map[string]*Acl)
+	}
+	s.attach(resource, aclV)
+	s.notify(resource, nil)
+}
+
+// Delete drops the Acl protecting resource.
+func (s *Store) Delete(resource string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stop, ok := s.detach[resource]; ok {
+		stop()
+		delete(s.detach, resource)
+	}
+	delete(s.acls, resource)
+	s.notify(resource, nil)
+}
+
+// Resources returns the sorted identifiers of every resource with an Acl.
+func (s *Store) Resources() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	resources := make([]string, 0, len(s.acls))
+	for resource := range s.acls {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+
+	return resources
+}
+
+// BlockInheritance stops resource from inheriting from its ancestors.
+func (s *Store) BlockInheritance(resource string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.blocked == nil {
+		s.blocked = make(map[string]struct{})
+	}
+	s.blocked[resource] = emptyStruct
+	s.notify(resource, nil)
+}
+
+// AllowInheritance undoes BlockInheritance.
+func (s *Store) AllowInheritance(resource string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.blocked, resource)
+	s.notify(resource, nil)
+}
+
+// attach makes aclV the Acl protecting resource and relays its changes
+// to the observers of s. It has to be called with s.mu held.
+func (s *Store) attach(resource string, aclV *Acl) {
+	if stop, ok := s.detach[resource]; ok {
+		stop()
+		delete(s.detach, resource)
+	}
+
+	s.acls[resource] = aclV
+	if aclV == nil {
+		return
+	}
+
+	if s.detach == nil {
+		s.detach = make(map[string]func())
+	}
+	s.detach[resource] = aclV.observe(func(m *Mutation) {
+		s.notify(resource, m)
+	})
+}
+
+// observe makes s call fn after every change to the Acl of a resource,
+// with that Acl locked, and after every change to the set of Acls or to
+// inheritance, with a nil Mutation. It returns a function that stops it.
+func (s *Store) observe(fn func(resource string, m *Mutation)) (stop func()) {
+	o := &storeObserver{fn: fn}
+
+	s.observers.mu.Lock()
+	defer s.observers.mu.Unlock()
+
+	if s.observers.fns == nil {
+		s.observers.fns = make(map[*storeObserver]struct{})
+	}
+	s.observers.fns[o] = emptyStruct
+
+	return func() {
+		s.observers.mu.Lock()
+		defer s.observers.mu.Unlock()
+
+		delete(s.observers.fns, o)
+	}
+}
+
+func (s *Store) notify(resource string, m *Mutation) {
+	s.observers.mu.RLock()
+	defer s.observers.mu.RUnlock()
+
+	for o := range s.observers.fns {
+		o.fn(resource, m)
+	}
+}
+
+// ancestry returns resource followed by the ancestors it inherits from,
+// nearest first, along with their Acls, which may be nil.
+func (s *Store) ancestry(resource string) (resources []string, acls []*Acl) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for current := resource; ; {
+		resources = append(resources, current)
+		acls = append(acls, s.acls[current])
+
+		if _, ok := s.blocked[current]; ok {
+			break
+		}
+
+		parent, ok := parentResource(current)
+		if !ok {
+			break
+		}
+		current = parent
+	}
+
+	return
+}
+
+func parentResource(resource string) (string, bool) {
+	if !strings.HasPrefix(resource, "/") {
+		return "", false
+	}
+
+	cleaned := path.Clean(resource)
+	if cleaned == "/" {
+		return "", false
+	}
+
+	return path.Dir(cleaned), true
+}
+
+// Check reports which permissions principal holds on resource, walking its
+// ancestry. For the permissions that are held, sources lists, nearest first,
+// the resources that contributed them; a bit granted at several levels is
+// attributed to the nearest one. Principals that are not registered anywhere
+// on the chain hold no permissions.
+func (s *Store) Check(principal, resource string, permissions ...permission.Permission) (wasSet, notSet []permission.Permission, sources []Source, err error) {
+	resources, acls := s.ancestry(resource)
+
+	granted, denied := permission.None, permission.None
+	contributed := make([]permission.Permission, len(acls))
+	for i, aclV := range acls {
+		if aclV == nil {
+			continue
+		}
+
+		g, d, gErr := aclV.effective(principal)
+		if errors.Is(gErr, ErrUserDoesnotExist) || errors.Is(gErr, ErrUninitializedACL) {
+			continue
+		}
+		if gErr != nil {
+			err = gErr
+			return
+		}
+
+		contributed[i] = g &^ granted
+		granted |= g
+		denied |= d
+	}
+
+	held := permission.None
+	for _, perm := range permissions {
+		ptr := &notSet
+		if perm&denied == permission.None && perm&granted == perm {
+			ptr = &wasSet
+			held |= perm
+		}
+
+		*ptr = append(*ptr, perm)
+	}
+
+	for i, bits := range contributed {
+		if bits &= held; bits != permission.None {
+			sources = append(sources, Source{Resource: resources[i], Permission: bits})
+		}
+	}
+
+	return
+}
+
+// Authorize reports whether principal holds every one of permissions
+// on resource, including through inheritance.
+func (s *Store) Authorize(principal, resource string, permissions ...permission.Permission) (bool, error) {
+	_, notSet, _, err := s.Check(principal, resource, permissions...)
+	if err != nil {
+		return false, err
+	}
+
+	return len(notSet) == 0, nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+func TestStoreAuthorize(t *testing.T) {
+	store := NewStore()
+
+	doc := store.Acl("doc-1")
+	if doc != store.Acl("doc-1") {
+		t.Errorf("expected the same Acl for the same resource")
+	}
+
+	if err := doc.RegisterUser("alice"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := doc.Insert("alice", permission.Write); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	cases := []struct {
+		principal, resource string
+		perms               []permission.Permission
+		want                bool
+	}{
+		{principal: "alice", resource: "doc-1", perms: []permission.Permission{permission.Read, permission.Write}, want: true},
+		{principal: "alice", resource: "doc-1", perms: []permission.Permission{permission.Delete}, want: false},
+		{principal: "bob", resource: "doc-1", perms: []permission.Permission{permission.Read}, want: false},
+		{principal: "alice", resource: "doc-2", perms: []permission.Permission{permission.Read}, want: false},
+	}
+
+	for _, tc := range cases {
+		got, err := store.Authorize(tc.principal, tc.resource, tc.perms...)
+		if err != nil {
+			t.Errorf("%s on %s: unexpected err %v", tc.principal, tc.resource, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s on %s %v: wanted %v got %v", tc.principal, tc.resource, tc.perms, tc.want, got)
+		}
+	}
+
+	if _, ok := store.Lookup("doc-2"); ok {
+		t.Errorf("Authorize should not have created an Acl for doc-2")
+	}
+}
+
+func TestStoreConcurrentUse(t *testing.T) {
+	store := NewStore()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			resource := fmt.Sprintf("bucket-%d", i%2)
+			user := fmt.Sprintf("user-%d", i)
+			aclV := store.Acl(resource)
+			if err := aclV.RegisterUser(user); err != nil {
+				t.Errorf("register %s: %v", user, err)
+			}
+			aclV.Insert(user, permission.Read)
+			if ok, err := store.Authorize(user, resource, permission.Read); !ok || err != nil {
+				t.Errorf("%s on %s: wanted authorized, got %v %v", user, resource, ok, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := store.Resources(); len(got) != 2 {
+		t.Errorf("expected 2 resources, got %v", got)
+	}
+}
+
+func TestStoreInheritance(t *testing.T) {
+	store := NewStore()
+
+	mustStoa := func(resource, s string) {
+		aclV, err := Stoa(s)
+		if err != nil {
+			t.Fatalf("%s: %v", resource, err)
+		}
+		store.Set(resource, aclV)
+	}
+
+	mustStoa("/", "admin-delete")
+	mustStoa("/projects/a", "alice-read:bob-read")
+	mustStoa("/projects/a/docs", "alice-write:bob-!read")
+	mustStoa("/projects/a/docs/private", "carol-read")
+	store.BlockInheritance("/projects/a/docs/private")
+
+	cases := []struct {
+		principal, resource string
+		perm                permission.Permission
+		want                bool
+		sources             []Source
+	}{
+		{
+			principal: "alice", resource: "/projects/a/docs/x", perm: permission.Write | permission.List, want: true,
+			sources: []Source{
+				{Resource: "/projects/a/docs", Permission: permission.Write | permission.List},
+			},
+		},
+		{
+			principal: "admin", resource: "/projects/a/docs/x", perm: permission.Delete, want: true,
+			sources: []Source{{Resource: "/", Permission: permission.Delete}},
+		},
+		{principal: "bob", resource: "/projects/a/docs/x", perm: permission.Read, want: false},
+		{
+			principal: "bob", resource: "/projects/a", perm: permission.Read, want: true,
+			sources: []Source{{Resource: "/projects/a", Permission: permission.Read}},
+		},
+		{principal: "alice", resource: "/projects/a/docs/private", perm: permission.Read, want: false},
+		{principal: "admin", resource: "/projects/a/docs/private/y", perm: permission.Delete, want: false},
+		{
+			principal: "carol", resource: "/projects/a/docs/private/y", perm: permission.Read, want: true,
+			sources: []Source{{Resource: "/projects/a/docs/private", Permission: permission.Read}},
+		},
+	}
+
+	for _, tc := range cases {
+		wasSet, _, sources, err := store.Check(tc.principal, tc.resource, tc.perm)
+		if err != nil {
+			t.Errorf("%s on %s: unexpected err %v", tc.principal, tc.resource, err)
+		}
+
+		if got := len(wasSet) == 1; got != tc.want {
+			t.Errorf("%s on %s %q: wanted %v got %v", tc.principal, tc.resource, tc.perm, tc.want, got)
+		}
+
+		if got, want := fmt.Sprint(sources), fmt.Sprint(tc.sources); got != want {
+			t.Errorf("%s on %s %q: wanted sources %s got %s", tc.principal, tc.resource, tc.perm, want, got)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"math/bits"
+
+	"github.com/odeke-em/acl/scope"
+)
+
+const (
+	trieBits  = 5
+	trieWidth = 1 << trieBits
+	hashBits  = 64
+)
+
+// trie is a persistent hash array mapped trie from scopes to their
+// resolutions. Updates copy only the nodes on the path to the scope
+// they change, so a snapshot shares everything else with the one it
+// was advanced from. The zero value is an empty trie.
+type trie struct {
+	root *trieNode
+	size int
+}
+
+// trieNode holds one entry for each bit set in bitmap, in slot order.
+// Nodes below hashBits hold scopes whose hashes collide entirely, in
+// no particular order.
+type trieNode struct {
+	bitmap  uint32
+	entries []trieEntry
+}
+
+// trieEntry is either a leaf or, if next is set, the subtrie
+// of the scopes that share its slot.
+type trieEntry struct {
+	leaf *trieLeaf
+	next *trieNode
+}
+
+// trieLeaf is a scope with its hash and resolution.
+type trieLeaf struct {
+	hash  uint64
+	key   scope.Scope
+	value *resolution
+}
+
+// get returns the resolution of sc.
+func (t trie) get(sc scope.Scope) (*resolution, bool) {
+	h := hashOf(sc)
+	n := t.root
+	for shift := uint(0); n != nil; shift += trieBits {
+		if shift >= hashBits {
+			for _, e := range n.entries {
+				if e.leaf.key == sc {
+					return e.leaf.value, true
+				}
+			}
+			return nil, false
+		}
+
+		bit := slotBit(h, shift)
+		if n.bitmap&bit == 0 {
+			return nil, false
+		}
+
+		e := &n.entries[bits.OnesCount32(n.bitmap&(bit-1))]
+		if e.next == nil {
+			if e.leaf.key == sc {
+				return e.leaf.value, true
+			}
+			return nil, false
+		}
+		n = e.next
+	}
+
+	return nil, false
+}
+
+// with returns a copy of t with r as the resolution of sc.
+func (t trie) with(sc scope.Scope, r *resolution) trie {
+	root := t.root
+	if root == nil {
+		root = &trieNode{}
+	}
+
+	root, added := root.with(&trieLeaf{hash: hashOf(sc), key: sc, value: r}, 0)
+	if added {
+		t.size++
+	}
+	t.root = root
+	return t
+}
+
+// without returns a copy of t without sc.
+func (t trie) without(sc scope.Scope) trie {
+	if t.root == nil {
+		return t
+	}
+
+	root, removed := t.root.without(hashOf(sc), sc, 0)
+	if removed {
+		t.size--
+	}
+	t.root = root
+	return t
+}
+
+// each calls fn with every scope in t and its resolution.
+func (t trie) each(fn func(scope.Scope, *resolution)) {
+	if t.root != nil {
+		t.root.each(fn)
+	}
+}
+
+func (n *trieNode) with(leaf *trieLeaf, shift uint) (*trieNode, bool) {
+	if shift >= hashBits {
+		for i, e := range n.entries {
+			if e.leaf.key == leaf.key {
+				c := n.clone()
+				c.entries[i].leaf = leaf
+				return c, false
+			}
+		}
+		return n.inserted(len(n.entries), 0, trieEntry{leaf: leaf}), true
+	}
+
+	bit := slotBit(leaf.hash, shift)
+	i := bits.OnesCount32(n.bitmap & (bit - 1))
+	if n.bitmap&bit == 0 {
+		return n.inserted(i, bit, trieEntry{leaf: leaf}), true
+	}
+
+	e := n.entries[i]
+	c := n.clone()
+	switch {
+	case e.next != nil:
+		next, added := e.next.with(leaf, shift+trieBits)
+		c.entries[i].next = next
+		return c, added
+	case e.leaf.key == leaf.key:
+		c.entries[i].leaf = leaf
+		return c, false
+	}
+
+	next, _ := (&trieNode{}).with(e.leaf, shift+trieBits)
+	next, _ = next.with(leaf, shift+trieBits)
+	c.entries[i] = trieEntry{next: next}
+	return c, true
+}
+
+func (n *trieNode) without(h uint64, sc scope.Scope, shift uint) (*trieNode, bool) {
+	if shift >= hashBits {
+		for i, e := range n.entries {
+			if e.leaf.key == sc {
+				return n.removed(i, 0), true
+			}
+		}
+		return n, false
+	}
+
+	bit := slotBit(h, shift)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+
+	i := bits.OnesCount32(n.bitmap & (bit - 1))
+	e := n.entries[i]
+	if e.next == nil {
+		if e.leaf.key != sc {
+			return n, false
+		}
+		return n.removed(i, bit), true
+	}
+
+	next, removed := e.next.without(h, sc, shift+trieBits)
+	if !removed {
+		return n, false
+	}
+
+	c := n.clone()
+	switch {
+	case len(next.entries) == 0:
+		return n.removed(i, bit), true
+	case len(next.entries) == 1 && next.entries[0].next == nil:
+		// A lone scope moves back up into the slot its hash led to.
+		c.entries[i] = next.entries[0]
+	default:
+		c.entries[i].next = next
+	}
+	return c, true
+}
+
+func (n *trieNode) each(fn func(scope.Scope, *resolution)) {
+	for _, e := range n.entries {
+		if e.next != nil {
+			e.next.each(fn)
+		} else {
+			fn(e.leaf.key, e.leaf.value)
+		}
+	}
+}
+
+func (n *trieNode) clone() *trieNode {
+	return &trieNode{bitmap: n.bitmap, entries: append([]trieEntry(nil), n.entries...)}
+}
+
+// inserted returns a copy of n with e inserted at i and bit set.
+func (n *trieNode) inserted(i int, bit uint32, e trieEntry) *trieNode {
+	entries := make([]trieEntry, len(n.entries)+1)
+	copy(entries, n.entries[:i])
+	entries[i] = e
+	copy(entries[i+1:], n.entries[i:])
+	return &trieNode{bitmap: n.bitmap | bit, entries: entries}
+}
+
+// removed returns a copy of n without the entry at i and with bit cleared.
+func (n *trieNode) removed(i int, bit uint32) *trieNode {
+	entries := make([]trieEntry, 0, len(n.entries)-1)
+	entries = append(entries, n.entries[:i]...)
+	entries = append(entries, n.entries[i+1:]...)
+	return &trieNode{bitmap: n.bitmap &^ bit, entries: entries}
+}
+
+func slotBit(h uint64, shift uint) uint32 {
+	return uint32(1) << ((h >> shift) & (trieWidth - 1))
+}
+
+// hashOf hashes sc with FNV-1a.
+func hashOf(sc scope.Scope) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range []byte(sc.String()) {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+
+	return h
+}
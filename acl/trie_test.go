@@ -0,0 +1,118 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"fmt"
+	"testing"
+	"testing/quick"
+
+	"github.com/odeke-em/acl/scope"
+)
+
+func TestTrieMatchesMap(t *testing.T) {
+	matches := func(ops []uint16) bool {
+		var tr trie
+		want := make(map[scope.Scope]*resolution)
+		for _, op := range ops {
+			sc, _ := scope.New(fmt.Sprintf("u%d", op%200))
+			if op&0x8000 != 0 {
+				tr = tr.without(sc)
+				delete(want, sc)
+			} else {
+				r := &resolution{}
+				tr = tr.with(sc, r)
+				want[sc] = r
+			}
+		}
+
+		got := make(map[scope.Scope]*resolution)
+		tr.each(func(sc scope.Scope, r *resolution) { got[sc] = r })
+		if len(got) != len(want) || tr.size != len(want) {
+			return false
+		}
+		for sc, r := range want {
+			if got[sc] != r {
+				return false
+			}
+			if found, ok := tr.get(sc); !ok || found != r {
+				return false
+			}
+		}
+		return true
+	}
+
+	if err := quick.Check(matches, &quick.Config{MaxCount: 300}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTrieSharesUntouchedNodes(t *testing.T) {
+	var tr trie
+	for i := 0; i < 1000; i++ {
+		sc, _ := scope.New(fmt.Sprintf("u%d", i))
+		tr = tr.with(sc, &resolution{})
+	}
+
+	sc, _ := scope.New("u0")
+	next := tr.with(sc, &resolution{})
+
+	shared := 0
+	for i := range tr.root.entries {
+		if tr.root.entries[i] == next.root.entries[i] {
+			shared++
+		}
+	}
+	if shared != len(tr.root.entries)-1 {
+		t.Errorf("expected all but one root entry to be shared, got %d of %d", shared, len(tr.root.entries))
+	}
+	if old, _ := tr.get(sc); old == nil {
+		t.Errorf("expected the original trie to be unchanged")
+	}
+}
+
+func TestTrieCollisions(t *testing.T) {
+	var scopes []scope.Scope
+	for _, name := range []string{"a", "b", "c"} {
+		sc, _ := scope.New(name)
+		scopes = append(scopes, sc)
+	}
+
+	// Every scope gets the same hash so that they all end up
+	// in one node below the last level.
+	n := &trieNode{}
+	for _, sc := range scopes {
+		n, _ = n.with(&trieLeaf{hash: 42, key: sc, value: &resolution{}}, 0)
+	}
+
+	count := 0
+	n.each(func(scope.Scope, *resolution) { count++ })
+	if count != len(scopes) {
+		t.Fatalf("expected %d scopes got %d", len(scopes), count)
+	}
+
+	for i, sc := range scopes {
+		var removed bool
+		if n, removed = n.without(42, sc, 0); !removed {
+			t.Fatalf("expected %v to be removed", sc)
+		}
+
+		count = 0
+		n.each(func(scope.Scope, *resolution) { count++ })
+		if want := len(scopes) - i - 1; count != want {
+			t.Errorf("expected %d scopes left got %d", want, count)
+		}
+	}
+}
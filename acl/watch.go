@@ -0,0 +1,143 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"context"
+	"time"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+// watchBuffer is the number of changes buffered for each watcher.
+const watchBuffer = 128
+
+// Mutation describes a successful change to an Acl.
+type Mutation struct {
+	Op Op
+	// Scope is the scope that was changed, or the group for
+	// membership operations. It is empty for role definitions.
+	Scope string
+	// Subject is the member for membership operations
+	// and the role name for role operations.
+	Subject string
+	// Permissions holds the bits that were inserted, removed, denied,
+	// undenied or swept, or the permissions a role was defined with.
+	Permissions permission.Permission
+	// Includes lists the roles that a role was defined to include.
+	Includes []string
+	// Expiry is set for temporary grants.
+	Expiry time.Time
+	// TTL is the lifetime that OpSetTTL gave grants made through Insert.
+	TTL time.Duration
+}
+
+// Change is a Mutation along with its position in the
+// sequence of every mutation made to the Acl.
+type Change struct {
+	Seq uint64
+	Mutation
+}
+
+// Watch returns a channel that receives every change made to a until
+// ctx is done, at which point the channel is closed. Sequence numbers
+// increase by one with every change; a watcher that falls more than a
+// buffer's worth behind misses changes, notices the gap in sequence
+// numbers and can resync through Versioned.
+func (a *Acl) Watch(ctx context.Context) <-chan Change {
+	ch := make(chan Change, watchBuffer)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if ctx.Err() != nil {
+		close(ch)
+		return ch
+	}
+
+	if a.watchers == nil {
+		a.watchers = make(map[chan Change]struct{})
+	}
+	a.watchers[ch] = emptyStruct
+
+	context.AfterFunc(ctx, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		delete(a.watchers, ch)
+		close(ch)
+	})
+
+	return ch
+}
+
+// Versioned returns the text form of a along with the sequence
+// number of the last change reflected in it.
+func (a *Acl) Versioned() (string, uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.formatLocked(identity), a.seq
+}
+
+// publish hands m to every watcher without blocking.
+// It has to be called with a.mu held.
+func (a *Acl) publish(m Mutation) {
+	a.seq++
+
+	change := Change{Seq: a.seq, Mutation: m}
+	for ch := range a.watchers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+}
+
+// observer is a callback registered through observe.
+type observer struct {
+	fn func(*Mutation)
+}
+
+// observe makes a call fn, with a.mu held, after every attempted change,
+// including failed ones, and returns a function that stops it. A nil
+// Mutation means that anything may have changed. fn must not call back
+// into a.
+func (a *Acl) observe(fn func(*Mutation)) (stop func()) {
+	o := &observer{fn: fn}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.observers == nil {
+		a.observers = make(map[*observer]struct{})
+	}
+	a.observers[o] = emptyStruct
+
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		delete(a.observers, o)
+	}
+}
+
+// notify hands m to every observer.
+// It has to be called with a.mu held.
+func (a *Acl) notify(m *Mutation) {
+	for o := range a.observers {
+		o.fn(m)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	acl := Acl{}
+	changes := acl.Watch(ctx)
+
+	acl.RegisterUser("frank")
+	acl.Insert("frank", permission.Read, permission.Write)
+	acl.Check("frank", permission.Read)
+	acl.Remove("frank", permission.Write, permission.Execute)
+	acl.DeRegisterUser("frank")
+	acl.DeRegisterUser("frank")
+
+	want := []Change{
+		{Seq: 1, Mutation: Mutation{Op: OpRegisterUser, Scope: "frank"}},
+		{Seq: 2, Mutation: Mutation{Op: OpInsert, Scope: "frank", Permissions: permission.Read | permission.Write}},
+		{Seq: 3, Mutation: Mutation{Op: OpRemove, Scope: "frank", Permissions: permission.Write}},
+		{Seq: 4, Mutation: Mutation{Op: OpDeRegisterUser, Scope: "frank"}},
+	}
+
+	for i, w := range want {
+		select {
+		case got := <-changes:
+			if got.Seq != w.Seq || got.Op != w.Op || got.Scope != w.Scope || got.Permissions != w.Permissions {
+				t.Errorf("#%d: wanted %+v got %+v", i, w, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("#%d: timed out", i)
+		}
+	}
+
+	if text, seq := acl.Versioned(); text != Header || seq != 4 {
+		t.Errorf("expected an empty acl at seq 4, got %q at %d", text, seq)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-changes:
+		if ok {
+			t.Errorf("expected no further changes")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the channel to be closed once the context was done")
+	}
+}
+
+func TestWatchSlowConsumerSeesGap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	acl := Acl{}
+	changes := acl.Watch(ctx)
+
+	acl.RegisterUser("gina")
+	for i := 0; i < watchBuffer*2; i++ {
+		acl.Insert("gina", permission.Read)
+	}
+
+	var last uint64
+	gap := false
+	for i := 0; i < watchBuffer; i++ {
+		change := <-changes
+		if change.Seq != last+1 {
+			gap = true
+		}
+		last = change.Seq
+	}
+	if gap {
+		t.Errorf("expected the buffered changes to be contiguous")
+	}
+
+	acl.Remove("gina", permission.Read)
+	if change := <-changes; change.Seq == last+1 {
+		t.Errorf("expected a gap after overflowing the buffer, got seq %d after %d", change.Seq, last)
+	}
+}
+
+func TestWatchDoesNotLeak(t *testing.T) {
+	acl := Acl{}
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		acl.Watch(ctx)
+		cancel()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	acl.mu.Lock()
+	watching := len(acl.watchers)
+	acl.mu.Unlock()
+
+	if watching != 0 {
+		t.Errorf("expected every watcher to have been dropped, %d remain", watching)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("goroutines leaked: %d before, %d after", before, after)
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"sort"
+
+	"github.com/odeke-em/acl/permission"
+	"github.com/odeke-em/acl/scope"
+)
+
+// holderIndex maps every permission bit to the scopes that are granted
+// it directly, permanently or temporarily. It is built the first time
+// WhoCan needs it and kept up to date by every mutation after that.
+type holderIndex struct {
+	bits   map[permission.Permission]map[scope.Scope]struct{}
+	scopes map[scope.Scope]permission.Permission
+}
+
+// WhoCan returns, sorted, every scope that holds all of perm, as Check
+// would decide it now: directly, through roles or through groups, and
+// not denied any of it.
+func (a *Acl) WhoCan(perm permission.Permission) []string {
+	scopes, _ := a.WhoCanPage(perm, "", 0)
+	return scopes
+}
+
+// WhoCanPage returns up to limit of the scopes that WhoCan would, starting
+// after cursor, which is empty for the first page. A limit of zero or less
+// returns every remaining scope. next is the cursor for the following page
+// and is empty once there are no more scopes.
+func (a *Acl) WhoCanPage(perm permission.Permission, cursor string, limit int) (scopes []string, next string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	candidates := a.candidates(perm)
+	start := sort.SearchStrings(candidates, cursor)
+	if start < len(candidates) && candidates[start] == cursor {
+		start++
+	}
+
+	now := a.currentTime()
+	for _, candidate := range candidates[start:] {
+		sc, _ := scope.New(candidate)
+		granted, denied := a.resolve(sc, a.roleMask).at(now)
+		if granted&perm != perm || denied&perm != permission.None {
+			continue
+		}
+
+		if limit > 0 && len(scopes) == limit {
+			next = scopes[len(scopes)-1]
+			break
+		}
+		scopes = append(scopes, candidate)
+	}
+
+	return
+}
+
+// candidates returns, sorted, every registered scope that could hold
+// perm, which is those granted, directly or through a role, a bit that
+// implies the lowest bit of perm, and the members of such groups.
+// It has to be called with a.mu held.
+func (a *Acl) candidates(perm permission.Permission) []string {
+	found := make(map[scope.Scope]struct{})
+	if perm == permission.None {
+		for sc := range a.rules {
+			found[sc] = emptyStruct
+		}
+		return sortedScopes(found)
+	}
+
+	a.buildHolders()
+
+	lowest := perm & -perm
+	implies := func(p permission.Permission) bool {
+		return permission.Closure(p)&lowest != permission.None
+	}
+
+	for bit, holders := range a.holders.bits {
+		if implies(bit) {
+			for sc := range holders {
+				found[sc] = emptyStruct
+			}
+		}
+	}
+
+	for sc, names := range a.assigned {
+		for name := range names {
+			if implies(a.roleMask(name)) {
+				found[sc] = emptyStruct
+				break
+			}
+		}
+	}
+
+	pending := make([]scope.Scope, 0, len(found))
+	for sc := range found {
+		pending = append(pending, sc)
+	}
+	for len(pending) > 0 {
+		group := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for member := range a.members[group] {
+			if _, ok := found[member]; !ok {
+				found[member] = emptyStruct
+				pending = append(pending, member)
+			}
+		}
+	}
+
+	for sc := range found {
+		if _, ok := a.rules[sc]; !ok {
+			delete(found, sc)
+		}
+	}
+
+	return sortedScopes(found)
+}
+
+// buildHolders indexes every scope, unless that was done already.
+// It has to be called with a.mu held.
+func (a *Acl) buildHolders() {
+	if a.holders != nil {
+		return
+	}
+
+	a.holders = &holderIndex{
+		bits:   make(map[permission.Permission]map[scope.Scope]struct{}),
+		scopes: make(map[scope.Scope]permission.Permission),
+	}
+	for sc := range a.rules {
+		a.holders.update(sc, a.directBits(sc))
+	}
+	for sc := range a.expiries {
+		a.holders.update(sc, a.directBits(sc))
+	}
+}
+
+// reindex brings the index up to date for userId after a mutation.
+// It has to be called with a.mu held.
+func (a *Acl) reindex(userId string) {
+	if a.holders == nil {
+		return
+	}
+
+	sc, err := scope.New(userId)
+	if err != nil {
+		return
+	}
+	a.holders.update(sc, a.directBits(sc))
+}
+
+// directBits returns the bits granted to sc itself, including temporary
+// grants that have expired but were not swept yet.
+func (a *Acl) directBits(sc scope.Scope) permission.Permission {
+	bits := a.rules[sc]
+	for bit := range a.expiries[sc] {
+		bits |= bit
+	}
+
+	return bits
+}
+
+// update records that sc is directly granted bits.
+func (idx *holderIndex) update(sc scope.Scope, bits permission.Permission) {
+	old := idx.scopes[sc]
+	if old == bits {
+		return
+	}
+
+	forEachBit(old&^bits, func(bit permission.Permission) {
+		delete(idx.bits[bit], sc)
+		if len(idx.bits[bit]) == 0 {
+			delete(idx.bits, bit)
+		}
+	})
+	forEachBit(bits&^old, func(bit permission.Permission) {
+		holders, ok := idx.bits[bit]
+		if !ok {
+			holders = make(map[scope.Scope]struct{})
+			idx.bits[bit] = holders
+		}
+		holders[sc] = emptyStruct
+	})
+
+	if bits == permission.None {
+		delete(idx.scopes, sc)
+	} else {
+		idx.scopes[sc] = bits
+	}
+}
+
+func sortedScopes(set map[scope.Scope]struct{}) []string {
+	names := make([]string, 0, len(set))
+	for sc := range set {
+		names = append(names, sc.String())
+	}
+	sort.Strings(names)
+
+	return names
+}
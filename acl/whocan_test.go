@@ -0,0 +1,160 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acl
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+func TestWhoCan(t *testing.T) {
+	fc := newFakeClock()
+	acl, err := Stoa(Header + `
+editor=write
+alice-delete
+bob-read
+carol-editor
+dave-read-!read
+erin
+staff-write
+staff+=erin`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	acl.SetClock(fc.Now)
+	if _, err := acl.InsertFor("frank", time.Hour, permission.Write); err == nil {
+		t.Fatalf("expected an error for an unregistered user")
+	}
+	if err := acl.RegisterUser("frank"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := acl.InsertFor("frank", time.Hour, permission.Write); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	cases := []struct {
+		perm permission.Permission
+		want []string
+	}{
+		{perm: permission.Delete, want: []string{"alice"}},
+		{perm: permission.Write, want: []string{"alice", "carol", "erin", "frank", "staff"}},
+		{perm: permission.Read, want: []string{"alice", "bob", "carol", "erin", "frank", "staff"}},
+		{perm: permission.Read | permission.Execute, want: nil},
+		{perm: permission.None, want: []string{"alice", "bob", "carol", "dave", "erin", "frank", "staff"}},
+	}
+
+	for _, tc := range cases {
+		if got := acl.WhoCan(tc.perm); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: wanted %v got %v", tc.perm, tc.want, got)
+		}
+	}
+
+	fc.Advance(time.Hour)
+	want := []string{"alice", "carol", "erin", "staff"}
+	if got := acl.WhoCan(permission.Write); !reflect.DeepEqual(got, want) {
+		t.Errorf("after expiry: wanted %v got %v", want, got)
+	}
+}
+
+func TestWhoCanPage(t *testing.T) {
+	acl := Acl{}
+	for _, uid := range []string{"e", "d", "c", "b", "a", "x"} {
+		if err := acl.RegisterUser(uid); err != nil {
+			t.Fatalf("register %s: %v", uid, err)
+		}
+		if uid == "x" {
+			continue
+		}
+		if _, err := acl.Insert(uid, permission.Read); err != nil {
+			t.Fatalf("insert %s: %v", uid, err)
+		}
+	}
+
+	var pages [][]string
+	cursor := ""
+	for {
+		page, next := acl.WhoCanPage(permission.Read, cursor, 2)
+		pages = append(pages, page)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	want := [][]string{{"a", "b"}, {"c", "d"}, {"e"}}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("wanted pages %v got %v", want, pages)
+	}
+
+	// A cursor that is no longer a holder still works.
+	if err := acl.DeRegisterUser("b"); err != nil {
+		t.Fatalf("deregister: %v", err)
+	}
+	page, next := acl.WhoCanPage(permission.Read, "b", 2)
+	if !reflect.DeepEqual(page, []string{"c", "d"}) || next != "d" {
+		t.Errorf("after b: got %v, next %q", page, next)
+	}
+}
+
+// whoCanByCheck is WhoCan done the slow way, through Check.
+func whoCanByCheck(a *Acl, perm permission.Permission) []string {
+	var holders []string
+	for sc := range a.rules {
+		if held, _, _ := a.Check(sc.String(), perm); len(held) == 1 {
+			holders = append(holders, sc.String())
+		}
+	}
+	sort.Strings(holders)
+
+	return holders
+}
+
+func TestWhoCanAgreesWithCheck(t *testing.T) {
+	agrees := func(q quickAcl, more quickAcl) bool {
+		// Build the index half way through, so that the
+		// mutations replayed below have to maintain it.
+		q.WhoCan(permission.Read)
+
+		replayed, err := Stoa(more.String())
+		if err != nil {
+			return false
+		}
+		if err := q.Apply(mustDiff(t, q.Acl, replayed)); err != nil {
+			t.Logf("apply: %v", err)
+			return false
+		}
+		for _, name := range quickNames[:4] {
+			q.DeRegisterUser(name)
+		}
+
+		for _, perm := range append(quickPermissions, permission.Read|permission.Execute) {
+			want, got := whoCanByCheck(q.Acl, perm), q.WhoCan(perm)
+			if !reflect.DeepEqual(want, got) {
+				t.Logf("%v: wanted %v got %v", perm, want, got)
+				return false
+			}
+		}
+		return true
+	}
+
+	if err := quick.Check(agrees, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"sync"
+)
+
+// Async hands events over to another Auditor through a buffered channel
+// so that the Acl does not wait for slow sinks. Events are never dropped:
+// once the buffer is full, Audit blocks until there is room again.
+type Async struct {
+	next   Auditor
+	events chan Event
+	done   chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewAsync starts forwarding events to next, buffering up to buffer of them.
+func NewAsync(next Auditor, buffer int) *Async {
+	as := &Async{
+		next:   next,
+		events: make(chan Event, buffer),
+		done:   make(chan struct{}),
+	}
+
+	go as.run()
+	return as
+}
+
+func (as *Async) run() {
+	defer close(as.done)
+
+	for ev := range as.events {
+		as.next.Audit(ev)
+	}
+}
+
+// Audit queues ev. Events audited after Close are discarded.
+func (as *Async) Audit(ev Event) {
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+
+	if as.closed {
+		return
+	}
+	as.events <- ev
+}
+
+// Close waits until every queued event has been forwarded.
+func (as *Async) Close() error {
+	as.mu.Lock()
+	if !as.closed {
+		as.closed = true
+		close(as.events)
+	}
+	as.mu.Unlock()
+
+	<-as.done
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit defines the events that an acl.Acl reports for every
+// mutation and decision, along with sinks that record them.
+package audit
+
+import (
+	"time"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+// Event describes a single mutation of, or decision made by, an Acl.
+type Event struct {
+	Time time.Time `json:"time"`
+	// Op names the operation, e.g. "insert" or "check".
+	Op string `json:"op"`
+	// Actor is the principal on whose behalf the operation ran, if known.
+	Actor string `json:"actor,omitempty"`
+	// Scope is the scope that was operated on.
+	Scope string `json:"scope,omitempty"`
+	// Subject is the member for membership operations
+	// and the role for role operations.
+	Subject string `json:"subject,omitempty"`
+	// Before and After hold the permissions of Scope, or
+	// of Subject for role definitions, around a mutation.
+	Before permission.Permission `json:"before"`
+	After  permission.Permission `json:"after"`
+	// Expiry is set for temporary grants.
+	Expiry time.Time `json:"expiry,omitzero"`
+	// Requested and Decision are set for decisions.
+	Requested permission.Permission `json:"requested,omitempty"`
+	Decision  string                `json:"decision,omitempty"`
+	// Err holds the error, if any, that the operation failed with.
+	Err string `json:"error,omitempty"`
+}
+
+// Auditor receives events. Acls call Audit synchronously, holding their
+// lock for mutations and from many goroutines at once for checks, so
+// implementations must be safe for concurrent use, must not call back
+// into the Acl and should hand slow work off, for example to an Async.
+type Auditor interface {
+	Audit(Event)
+}
+
+// AuditorFunc adapts a function to the Auditor interface.
+type AuditorFunc func(Event)
+
+func (fn AuditorFunc) Audit(ev Event) {
+	fn(ev)
+}
@@ -0,0 +1,147 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/odeke-em/acl/permission"
+)
+
+func sampleEvents(n int) []Event {
+	events := []Event{}
+	for i := 0; i < n; i++ {
+		events = append(events, Event{
+			Time:   time.Unix(int64(1700000000+i), 0).UTC(),
+			Op:     "insert",
+			Actor:  "admin",
+			Scope:  "alice",
+			Before: permission.Read,
+			After:  permission.Read | permission.Write,
+		})
+	}
+	return events
+}
+
+func TestJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	jl, err := OpenJSONLines(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	events := sampleEvents(3)
+	for _, ev := range events {
+		jl.Audit(ev)
+	}
+	if err := jl.Err(); err != nil {
+		t.Errorf("unexpected write err %v", err)
+	}
+	if err := jl.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	jl, err = OpenJSONLines(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	jl.Audit(events[0])
+	jl.Close()
+
+	var buf bytes.Buffer
+	jl = NewJSONLines(&buf)
+	jl.Audit(events[0])
+
+	if strings.Contains(buf.String(), "expiry") || strings.Contains(buf.String(), "decision") {
+		t.Errorf("expected unset fields to be omitted, got %s", buf.String())
+	}
+
+	var decoded Event
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if decoded != events[0] {
+		t.Errorf("wanted %+v got %+v", events[0], decoded)
+	}
+}
+
+func TestAsyncForwardsEverything(t *testing.T) {
+	var mu sync.Mutex
+	var got []Event
+	sink := AuditorFunc(func(ev Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, ev)
+	})
+
+	as := NewAsync(sink, 2)
+	events := sampleEvents(50)
+	for _, ev := range events {
+		as.Audit(ev)
+	}
+	if err := as.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// Discarded since the Async is closed.
+	as.Audit(events[0])
+	as.Close()
+
+	if len(got) != len(events) {
+		t.Fatalf("expected %d events, got %d", len(events), len(got))
+	}
+	for i := range events {
+		if got[i] != events[i] {
+			t.Errorf("#%d: out of order", i)
+		}
+	}
+}
+
+func TestSlog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	s := NewSlog(logger, slog.LevelInfo)
+	ev := sampleEvents(1)[0]
+	s.Audit(ev)
+	ev.Err = "boom"
+	s.Audit(ev)
+
+	scanner := bufio.NewScanner(&buf)
+	levels := []string{}
+	for scanner.Scan() {
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if record["scope"] != "alice" || record["after"] != "read|write" {
+			t.Errorf("unexpected record %v", record)
+		}
+		levels = append(levels, record["level"].(string))
+	}
+
+	if strings.Join(levels, ",") != "INFO,WARN" {
+		t.Errorf("unexpected levels %v", levels)
+	}
+}
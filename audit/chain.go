@@ -0,0 +1,193 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// GenesisHash is the Prev of the first record of every chain.
+var GenesisHash = strings.Repeat("0", sha256.Size*2)
+
+// ChainRecord is one line of a hash-chained audit log. Hash is the
+// hex encoded SHA-256 of Seq, Prev and Event, each followed by a
+// newline, so altering, dropping or reordering records breaks the chain.
+type ChainRecord struct {
+	Seq   uint64          `json:"seq"`
+	Prev  string          `json:"prev"`
+	Event json.RawMessage `json:"event"`
+	Hash  string          `json:"hash"`
+}
+
+func chainHash(seq uint64, prev string, event []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n%s\n%s\n", seq, prev, event)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// BrokenLinkError reports the first record of a chain that fails to verify.
+type BrokenLinkError struct {
+	// Line is the 1-based line number of the record.
+	Line   int
+	Reason string
+}
+
+func (e *BrokenLinkError) Error() string {
+	return fmt.Sprintf("audit chain broken at line %d: %s", e.Line, e.Reason)
+}
+
+// Chain writes events as hash-chained ChainRecords, one per line.
+type Chain struct {
+	mu   sync.Mutex
+	w    io.Writer
+	c    io.Closer
+	seq  uint64
+	last string
+	err  error
+}
+
+// NewChain starts a new chain on w.
+func NewChain(w io.Writer) *Chain {
+	return &Chain{w: w, last: GenesisHash}
+}
+
+// OpenChain verifies the chain in the file at path, creating the file
+// if needed, and resumes appending to it. A file that fails to verify
+// is not appended to.
+func OpenChain(path string) (*Chain, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := Verify(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	ch := &Chain{w: f, c: f, last: GenesisHash}
+	if last != nil {
+		ch.seq, ch.last = last.Seq+1, last.Hash
+	}
+
+	return ch, nil
+}
+
+func (ch *Chain) Audit(ev Event) {
+	event, err := json.Marshal(ev)
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if err != nil {
+		ch.setErr(err)
+		return
+	}
+
+	rec := ChainRecord{Seq: ch.seq, Prev: ch.last, Event: event}
+	rec.Hash = chainHash(rec.Seq, rec.Prev, rec.Event)
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		ch.setErr(err)
+		return
+	}
+
+	if _, err := ch.w.Write(append(line, '\n')); err != nil {
+		ch.setErr(err)
+		return
+	}
+
+	ch.seq, ch.last = rec.Seq+1, rec.Hash
+}
+
+// Err returns the first error that recording an event failed with.
+func (ch *Chain) Err() error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	return ch.err
+}
+
+// Close closes the underlying file, if it was opened by OpenChain.
+func (ch *Chain) Close() error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.c == nil {
+		return nil
+	}
+	return ch.c.Close()
+}
+
+func (ch *Chain) setErr(err error) {
+	if ch.err == nil {
+		ch.err = err
+	}
+}
+
+// Verify walks the chain read from r and returns its last record,
+// which is nil for an empty chain. The first record that does not
+// verify is reported as a *BrokenLinkError.
+func Verify(r io.Reader) (last *ChainRecord, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	prev, seq := GenesisHash, uint64(0)
+	for line := 1; scanner.Scan(); line++ {
+		rec := new(ChainRecord)
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			return last, &BrokenLinkError{Line: line, Reason: err.Error()}
+		}
+
+		switch {
+		case rec.Seq != seq:
+			return last, &BrokenLinkError{Line: line, Reason: fmt.Sprintf("sequence %d, expected %d", rec.Seq, seq)}
+		case rec.Prev != prev:
+			return last, &BrokenLinkError{Line: line, Reason: "previous hash does not match"}
+		case rec.Hash != chainHash(rec.Seq, rec.Prev, rec.Event):
+			return last, &BrokenLinkError{Line: line, Reason: "hash does not match contents"}
+		}
+
+		last, prev, seq = rec, rec.Hash, seq+1
+	}
+
+	return last, scanner.Err()
+}
+
+// Mutations forwards to next every event except decisions, that is
+// events whose Op is "check", so that next records only changes.
+func Mutations(next Auditor) Auditor {
+	return AuditorFunc(func(ev Event) {
+		if ev.Op == "check" {
+			return
+		}
+		next.Audit(ev)
+	})
+}
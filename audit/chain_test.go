@@ -0,0 +1,110 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChainVerifies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.jsonl")
+
+	ch, err := OpenChain(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for _, ev := range sampleEvents(3) {
+		ch.Audit(ev)
+	}
+	ch.Close()
+
+	// Resuming continues the same chain.
+	ch, err = OpenChain(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	for _, ev := range sampleEvents(2) {
+		ch.Audit(ev)
+	}
+	if err := ch.Err(); err != nil {
+		t.Errorf("unexpected err %v", err)
+	}
+	ch.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+
+	last, err := Verify(f)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if last == nil || last.Seq != 4 {
+		t.Errorf("expected the last record to have seq 4, got %+v", last)
+	}
+}
+
+func TestChainDetectsTampering(t *testing.T) {
+	var buf bytes.Buffer
+	ch := NewChain(&buf)
+	for _, ev := range sampleEvents(4) {
+		ch.Audit(ev)
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(buf.String(), "\n"), "\n")
+
+	cases := []struct {
+		name string
+		log  string
+		line int
+	}{
+		{name: "altered", log: lines[0] + strings.Replace(lines[1], `"alice"`, `"mallory"`, 1) + lines[2], line: 2},
+		{name: "dropped", log: lines[0] + lines[2] + lines[3], line: 2},
+		{name: "reordered", log: lines[0] + lines[2] + lines[1], line: 2},
+		{name: "garbage", log: lines[0] + lines[1] + "{\n", line: 3},
+	}
+
+	for _, tc := range cases {
+		_, err := Verify(strings.NewReader(tc.log))
+
+		var broken *BrokenLinkError
+		if !errors.As(err, &broken) {
+			t.Errorf("%s: expected a BrokenLinkError, got %v", tc.name, err)
+			continue
+		}
+		if broken.Line != tc.line {
+			t.Errorf("%s: expected line %d, got %d: %v", tc.name, tc.line, broken.Line, broken)
+		}
+	}
+}
+
+func TestMutationsSkipsDecisions(t *testing.T) {
+	var got []Event
+	sink := Mutations(AuditorFunc(func(ev Event) { got = append(got, ev) }))
+
+	sink.Audit(Event{Op: "insert"})
+	sink.Audit(Event{Op: "check", Decision: "granted"})
+	sink.Audit(Event{Op: "deRegisterUser"})
+
+	if len(got) != 2 {
+		t.Errorf("expected 2 mutations, got %+v", got)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// JSONLines writes every event as one line of JSON.
+type JSONLines struct {
+	mu  sync.Mutex
+	w   io.Writer
+	c   io.Closer
+	err error
+}
+
+func NewJSONLines(w io.Writer) *JSONLines {
+	return &JSONLines{w: w}
+}
+
+// OpenJSONLines appends events to the file at path, creating it if needed.
+func OpenJSONLines(path string) (*JSONLines, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JSONLines{w: f, c: f}, nil
+}
+
+func (jl *JSONLines) Audit(ev Event) {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		jl.setErr(err)
+		return
+	}
+
+	jl.mu.Lock()
+	defer jl.mu.Unlock()
+
+	if _, err := jl.w.Write(append(line, '\n')); err != nil && jl.err == nil {
+		jl.err = err
+	}
+}
+
+// Err returns the first error that writing an event failed with.
+func (jl *JSONLines) Err() error {
+	jl.mu.Lock()
+	defer jl.mu.Unlock()
+
+	return jl.err
+}
+
+// Close closes the underlying file, if it was opened by OpenJSONLines.
+func (jl *JSONLines) Close() error {
+	jl.mu.Lock()
+	defer jl.mu.Unlock()
+
+	if jl.c == nil {
+		return nil
+	}
+	return jl.c.Close()
+}
+
+func (jl *JSONLines) setErr(err error) {
+	jl.mu.Lock()
+	defer jl.mu.Unlock()
+
+	if jl.err == nil {
+		jl.err = err
+	}
+}
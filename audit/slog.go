@@ -0,0 +1,73 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Slog logs every event as a structured record. Events
+// that carry an error are logged at slog.LevelWarn.
+type Slog struct {
+	logger *slog.Logger
+	level  slog.Level
+}
+
+// NewSlog logs events through logger at level,
+// or through slog.Default if logger is nil.
+func NewSlog(logger *slog.Logger, level slog.Level) *Slog {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &Slog{logger: logger, level: level}
+}
+
+func (s *Slog) Audit(ev Event) {
+	attrs := []slog.Attr{
+		slog.Time("time", ev.Time),
+		slog.String("op", ev.Op),
+	}
+
+	optional := []struct{ key, value string }{
+		{"actor", ev.Actor},
+		{"scope", ev.Scope},
+		{"subject", ev.Subject},
+		{"decision", ev.Decision},
+		{"error", ev.Err},
+	}
+	for _, kv := range optional {
+		if kv.value != "" {
+			attrs = append(attrs, slog.String(kv.key, kv.value))
+		}
+	}
+
+	if ev.Decision != "" {
+		attrs = append(attrs, slog.String("requested", ev.Requested.String()))
+	} else {
+		attrs = append(attrs, slog.String("before", ev.Before.String()), slog.String("after", ev.After.String()))
+	}
+	if !ev.Expiry.IsZero() {
+		attrs = append(attrs, slog.Time("expiry", ev.Expiry))
+	}
+
+	level := s.level
+	if ev.Err != "" && level < slog.LevelWarn {
+		level = slog.LevelWarn
+	}
+
+	s.logger.LogAttrs(context.Background(), level, "acl", attrs...)
+}
@@ -0,0 +1,137 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package permission
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrImplicationCycle  = errors.New("permission implication cycle")
+	ErrNotUnitPermission = errors.New("exactly one permission bit has to be set")
+)
+
+// builtinImplications are declared on every Registry returned by NewRegistry.
+var builtinImplications = []struct {
+	p, implied Permission
+}{
+	{p: Delete, implied: Write},
+	{p: Write, implied: Read},
+	{p: Read, implied: List},
+}
+
+// Imply declares in the DefaultRegistry that holding p implies holding implied.
+func Imply(p, implied Permission) error {
+	return DefaultRegistry.Imply(p, implied)
+}
+
+// Closure returns p together with every permission it implies,
+// according to the DefaultRegistry.
+func Closure(p Permission) Permission {
+	return DefaultRegistry.Closure(p)
+}
+
+// Minimal returns the smallest Permission whose Closure equals
+// that of p, according to the DefaultRegistry.
+func Minimal(p Permission) Permission {
+	return DefaultRegistry.Minimal(p)
+}
+
+// Generation returns a number that changes whenever an implication
+// is declared in the DefaultRegistry.
+func Generation() uint64 {
+	return DefaultRegistry.Generation()
+}
+
+// Imply declares that holding the single bit p implies holding every
+// bit in implied. Declarations that would make the graph cyclic are
+// rejected with ErrImplicationCycle.
+func (r *Registry) Imply(p, implied Permission) error {
+	if !powerOfTwo(p) {
+		return fmt.Errorf("%w: %d", ErrNotUnitPermission, p)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closureLocked(implied)&p != 0 {
+		return fmt.Errorf("%w: %d implies %d", ErrImplicationCycle, implied, p)
+	}
+
+	if r.implies == nil {
+		r.implies = make(map[Permission]Permission)
+	}
+	r.implies[p] |= implied
+	r.generation.Add(1)
+	return nil
+}
+
+// Generation returns a number that changes whenever an implication is
+// declared, so that closures worked out ahead of time can be checked
+// for staleness.
+func (r *Registry) Generation() uint64 {
+	return r.generation.Load()
+}
+
+// Closure returns p together with every permission that it
+// transitively implies.
+func (r *Registry) Closure(p Permission) Permission {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.closureLocked(p)
+}
+
+// Minimal drops from the Closure of p every bit that is implied by
+// another bit, leaving only the bits that have to be granted.
+func (r *Registry) Minimal(p Permission) Permission {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	closed := r.closureLocked(p)
+
+	// The graph is acyclic so every implied bit is reachable
+	// from some bit that is itself not implied.
+	redundant := None
+	for i := 0; i < bitCount; i++ {
+		bit := Permission(1) << uint(i)
+		if closed&bit == 0 {
+			continue
+		}
+		redundant |= r.closureLocked(r.implies[bit])
+	}
+
+	return closed &^ redundant
+}
+
+func (r *Registry) closureLocked(p Permission) Permission {
+	closed := p
+	for pending := p; pending != None; {
+		next := None
+		for i := 0; i < bitCount; i++ {
+			bit := Permission(1) << uint(i)
+			if pending&bit == 0 {
+				continue
+			}
+			next |= r.implies[bit]
+		}
+
+		pending = next &^ closed
+		closed |= next
+	}
+
+	return closed
+}
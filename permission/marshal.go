@@ -0,0 +1,107 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package permission
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrUnnamedPermission = errors.New("permission has no name")
+
+// Names returns the names of the bits set in p, from the least to the
+// most significant bit. It fails with ErrUnnamedPermission if any bit
+// has not been registered.
+func (r *Registry) Names(p Permission) ([]string, error) {
+	names := []string{}
+	for i := 0; i < bitCount; i++ {
+		bit := Permission(1) << uint(i)
+		if p&bit == 0 {
+			continue
+		}
+
+		r.mu.RLock()
+		name, ok := r.ptoa[bit]
+		r.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("%w: %#x", ErrUnnamedPermission, uint64(bit))
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// MarshalText encodes p the way String does, failing for unnamed bits.
+func (p Permission) MarshalText() ([]byte, error) {
+	if p == None {
+		return []byte(p.String()), nil
+	}
+
+	names, err := DefaultRegistry.Names(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(strings.Join(names, Separator)), nil
+}
+
+// UnmarshalText decodes the Separator joined names read by Atop.
+func (p *Permission) UnmarshalText(text []byte) error {
+	q, err := Atop(string(text))
+	if err != nil {
+		return err
+	}
+
+	*p = q
+	return nil
+}
+
+// MarshalJSON encodes p as the list of its names, as returned by Names.
+func (p Permission) MarshalJSON() ([]byte, error) {
+	names, err := DefaultRegistry.Names(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(names)
+}
+
+// UnmarshalJSON decodes a list of names, or a string in the text form.
+func (p *Permission) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		return p.UnmarshalText([]byte(text))
+	}
+
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+
+	q := None
+	for _, name := range names {
+		bit, err := DefaultRegistry.atopUnit(name)
+		if err != nil {
+			return err
+		}
+		q |= bit
+	}
+
+	*p = q
+	return nil
+}
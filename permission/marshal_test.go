@@ -0,0 +1,77 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package permission
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestPermissionJSON(t *testing.T) {
+	cases := []struct {
+		perm Permission
+		want string
+	}{
+		{perm: None, want: `[]`},
+		{perm: Read, want: `["read"]`},
+		{perm: Delete | List | Execute, want: `["list","execute","delete"]`},
+	}
+
+	for _, tc := range cases {
+		data, err := json.Marshal(tc.perm)
+		if err != nil {
+			t.Fatalf("%v: marshal: %v", tc.perm, err)
+		}
+		if got := string(data); got != tc.want {
+			t.Errorf("%v: wanted %s got %s", tc.perm, tc.want, got)
+		}
+
+		var decoded Permission
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("%s: unmarshal: %v", data, err)
+		}
+		if decoded != tc.perm {
+			t.Errorf("%s: wanted %v got %v", data, tc.perm, decoded)
+		}
+	}
+
+	var decoded Permission
+	if err := json.Unmarshal([]byte(`"read|write"`), &decoded); err != nil || decoded != Read|Write {
+		t.Errorf("expected read|write from a string, got %v %v", decoded, err)
+	}
+
+	for _, bad := range []string{`["read","nope"]`, `"nope"`, `7`} {
+		if err := json.Unmarshal([]byte(bad), &decoded); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+
+	if _, err := json.Marshal(Permission(1) << 60); !errors.Is(err, ErrUnnamedPermission) {
+		t.Errorf("expected ErrUnnamedPermission, got %v", err)
+	}
+}
+
+func TestPermissionText(t *testing.T) {
+	text, err := (Write | Read).MarshalText()
+	if err != nil || string(text) != "read|write" {
+		t.Errorf("expected read|write, got %q %v", text, err)
+	}
+
+	var p Permission
+	if err := p.UnmarshalText([]byte("execute | list")); err != nil || p != Execute|List {
+		t.Errorf("expected execute|list, got %v %v", p, err)
+	}
+}
@@ -14,13 +14,6 @@
 
 package permission
 
-import (
-	"fmt"
-	"strings"
-
-	"github.com/odeke-em/acl/common"
-)
-
 type Permission uint64
 
 const (
@@ -35,72 +28,14 @@ const (
 const Separator = "|"
 const UnknownStr = "unknown"
 
-var ptoaMap = map[Permission]string{
-	None:    "none",
-	List:    "list",
-	Write:   "write",
-	Delete:  "delete",
-	Read:    "read",
-	Execute: "execute",
-}
-
-var atopMap = func() (revMap map[string]Permission) {
-	revMap = make(map[string]Permission)
-	for k, v := range ptoaMap {
-		revMap[v] = k
-	}
-	return revMap
-}()
-
-// ptoa returns the unit permission string representation
-func ptoa(p Permission) string {
-	// Since no writes affect either the ptoaMap or
-	// atopMap, no need to lock any of them
-	repr, ok := ptoaMap[p]
-	if !ok {
-		return UnknownStr
-	}
-
-	return repr
-}
-
 // Atop returns the Permission whose bits are set by the
 // different representations of the word values
 func Atop(s string) (p Permission, err error) {
-	splits := strings.Split(s, Separator)
-	for _, strRaw := range splits {
-		str := strings.Trim(strRaw, " ")
-		if str == "" {
-			continue
-		}
-		result, resolvErr := atop(str)
-		if resolvErr != nil {
-			err = common.ReComposeError(err, resolvErr.Error())
-		} else {
-			p |= result
-		}
-	}
-
-	return
+	return DefaultRegistry.Atop(s)
 }
 
 func (p Permission) String() string {
-	if p == None { // TODO: Sanity check enforcement to ensure None is always "0"
-		return ptoa(p)
-	}
-
-	sects := []string{}
-
-	for i := Permission(1); i <= p; i <<= 1 {
-		result := p & i
-		if result == 0 {
-			continue
-		}
-		extracted := Permission(p & i)
-		sects = append(sects, ptoa(extracted))
-	}
-
-	return strings.Join(sects, Separator)
+	return DefaultRegistry.String(p)
 }
 
 type Permissioner struct {
@@ -143,12 +78,3 @@ var (
 	WritePermissioner   = unitPermPermissioner(Write)
 	ExecutePermissioner = unitPermPermissioner(Execute)
 )
-
-func atop(s string) (Permission, error) {
-	repr, ok := atopMap[s]
-	if !ok {
-		return None, fmt.Errorf("unknown permission %q", s)
-	}
-
-	return repr, nil
-}
@@ -0,0 +1,212 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package permission
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/odeke-em/acl/common"
+)
+
+const bitCount = 64
+
+var (
+	ErrNameTaken     = errors.New("permission name already registered")
+	ErrInvalidName   = errors.New("invalid permission name")
+	ErrBitsExhausted = errors.New("all permission bits are in use")
+)
+
+// Registry maps named permission bits to their string representations.
+// Applications register their own bits, typically at init time, on top
+// of the builtin ones.
+type Registry struct {
+	mu   sync.RWMutex
+	ptoa map[Permission]string
+	atop map[string]Permission
+	used Permission
+
+	// implies maps a single bit to the bits it directly implies.
+	implies map[Permission]Permission
+	// generation counts the implications declared after construction.
+	generation atomic.Uint64
+}
+
+// DefaultRegistry is the Registry used by Atop and Permission.String.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry returns a Registry that knows only about the builtin
+// permissions: none, list, read, write, execute and delete, where
+// delete implies write, write implies read and read implies list.
+func NewRegistry() *Registry {
+	r := &Registry{
+		ptoa:    make(map[Permission]string),
+		atop:    make(map[string]Permission),
+		implies: make(map[Permission]Permission),
+	}
+
+	builtins := map[Permission]string{
+		None:    "none",
+		List:    "list",
+		Write:   "write",
+		Delete:  "delete",
+		Read:    "read",
+		Execute: "execute",
+	}
+
+	for perm, name := range builtins {
+		r.ptoa[perm] = name
+		r.atop[name] = perm
+		r.used |= perm
+	}
+
+	for _, imp := range builtinImplications {
+		r.implies[imp.p] |= imp.implied
+	}
+
+	return r
+}
+
+// Register allocates the lowest free bit in the DefaultRegistry for name.
+func Register(name string) (Permission, error) {
+	return DefaultRegistry.Register(name)
+}
+
+// Register allocates the lowest free bit for name. Names are made up
+// of lowercase letters, digits and underscores so that they never clash
+// with the separators used when serializing permissions.
+func (r *Registry) Register(name string) (Permission, error) {
+	if !ValidName(name) {
+		return None, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.atop[name]; ok {
+		return None, fmt.Errorf("%w: %q", ErrNameTaken, name)
+	}
+
+	for i := 0; i < bitCount; i++ {
+		bit := Permission(1) << uint(i)
+		if r.used&bit != 0 {
+			continue
+		}
+
+		r.used |= bit
+		r.ptoa[bit] = name
+		r.atop[name] = bit
+		return bit, nil
+	}
+
+	return None, ErrBitsExhausted
+}
+
+// Lookup returns the bit registered under name.
+func (r *Registry) Lookup(name string) (Permission, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	perm, ok := r.atop[name]
+	return perm, ok
+}
+
+// Atop returns the Permission whose bits are set by the names
+// registered in r.
+func (r *Registry) Atop(s string) (p Permission, err error) {
+	splits := strings.Split(s, Separator)
+	for _, strRaw := range splits {
+		str := strings.Trim(strRaw, " ")
+		if str == "" {
+			continue
+		}
+		result, resolvErr := r.atopUnit(str)
+		if resolvErr != nil {
+			err = common.ReComposeError(err, resolvErr.Error())
+		} else {
+			p |= result
+		}
+	}
+
+	return
+}
+
+// String returns the Separator joined names of the bits set in p,
+// from the least to the most significant bit.
+func (r *Registry) String(p Permission) string {
+	if p == None { // TODO: Sanity check enforcement to ensure None is always "0"
+		return r.ptoaUnit(p)
+	}
+
+	sects := []string{}
+
+	for i := 0; i < bitCount; i++ {
+		bit := Permission(1) << uint(i)
+		if p&bit == 0 {
+			continue
+		}
+		sects = append(sects, r.ptoaUnit(bit))
+	}
+
+	return strings.Join(sects, Separator)
+}
+
+// ptoaUnit returns the unit permission string representation
+func (r *Registry) ptoaUnit(p Permission) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	repr, ok := r.ptoa[p]
+	if !ok {
+		return UnknownStr
+	}
+
+	return repr
+}
+
+func (r *Registry) atopUnit(s string) (Permission, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	repr, ok := r.atop[s]
+	if !ok {
+		return None, fmt.Errorf("unknown permission %q", s)
+	}
+
+	return repr, nil
+}
+
+// ValidName reports whether name is made up of only lowercase
+// letters, digits and underscores.
+func ValidName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9':
+		case r == '_':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,147 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package permission
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRegistryRegister(t *testing.T) {
+	r := NewRegistry()
+
+	approve, err := r.Register("approve")
+	if err != nil {
+		t.Fatalf("registering approve: %v", err)
+	}
+
+	if !ClearedOrLonePermission(approve) || approve == None {
+		t.Errorf("expected a single bit, got %d", approve)
+	}
+
+	if approve&(List|Read|Write|Execute|Delete) != 0 {
+		t.Errorf("approve %d overlaps with a builtin permission", approve)
+	}
+
+	parsed, err := r.Atop("read|approve")
+	if err != nil {
+		t.Errorf("parsing: %v", err)
+	}
+	if parsed != Read|approve {
+		t.Errorf("wanted %d got %d", Read|approve, parsed)
+	}
+
+	if got, want := r.String(parsed), "approve|read"; got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+
+	if _, err := r.Register("approve"); !errors.Is(err, ErrNameTaken) {
+		t.Errorf("expected ErrNameTaken, got %v", err)
+	}
+
+	if _, err := r.Register("read"); !errors.Is(err, ErrNameTaken) {
+		t.Errorf("expected ErrNameTaken for a builtin, got %v", err)
+	}
+}
+
+func TestRegistryInvalidNames(t *testing.T) {
+	r := NewRegistry()
+
+	cases := []string{"", "read|write", "a-b", "a:b", "Approve", "with space"}
+	for _, tc := range cases {
+		if _, err := r.Register(tc); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("%q: expected ErrInvalidName, got %v", tc, err)
+		}
+	}
+}
+
+func TestRegistryExhausted(t *testing.T) {
+	r := NewRegistry()
+
+	var all Permission
+	for i := 0; ; i++ {
+		bit, err := r.Register(fmt.Sprintf("p%d", i))
+		if errors.Is(err, ErrBitsExhausted) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("#%d: unexpected err %v", i, err)
+		}
+		all |= bit
+	}
+
+	if all|List|Read|Write|Execute|Delete != ^None {
+		t.Errorf("expected every bit to have been allocated, got %x", all)
+	}
+
+	if got := r.String(^None); got == "" {
+		t.Errorf("expected a non-empty representation for all bits")
+	}
+}
+
+func TestClosureAndMinimal(t *testing.T) {
+	r := NewRegistry()
+
+	cases := []struct {
+		value   Permission
+		closure Permission
+		minimal Permission
+	}{
+		{value: None, closure: None, minimal: None},
+		{value: Read, closure: Read | List, minimal: Read},
+		{value: Delete, closure: Delete | Write | Read | List, minimal: Delete},
+		{value: Write | Read | List, closure: Write | Read | List, minimal: Write},
+		{value: Execute | Read, closure: Execute | Read | List, minimal: Execute | Read},
+	}
+
+	for _, tc := range cases {
+		if got := r.Closure(tc.value); got != tc.closure {
+			t.Errorf("closure(%q): wanted %q got %q", tc.value, tc.closure, got)
+		}
+
+		if got := r.Minimal(tc.value); got != tc.minimal {
+			t.Errorf("minimal(%q): wanted %q got %q", tc.value, tc.minimal, got)
+		}
+	}
+}
+
+func TestImplyCycles(t *testing.T) {
+	r := NewRegistry()
+
+	if err := r.Imply(List, Delete); !errors.Is(err, ErrImplicationCycle) {
+		t.Errorf("expected ErrImplicationCycle, got %v", err)
+	}
+
+	if err := r.Imply(Execute, Execute); !errors.Is(err, ErrImplicationCycle) {
+		t.Errorf("expected ErrImplicationCycle for a self loop, got %v", err)
+	}
+
+	if err := r.Imply(Read|Write, List); !errors.Is(err, ErrNotUnitPermission) {
+		t.Errorf("expected ErrNotUnitPermission, got %v", err)
+	}
+
+	if err := r.Imply(Execute, Read); err != nil {
+		t.Fatalf("execute implies read: %v", err)
+	}
+
+	if got, want := r.Closure(Execute), Execute|Read|List; got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+
+	if err := r.Imply(List, Execute); !errors.Is(err, ErrImplicationCycle) {
+		t.Errorf("expected ErrImplicationCycle through execute, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scope
+
+import "encoding/json"
+
+// MarshalText encodes sc as its String.
+func (sc Scope) MarshalText() ([]byte, error) {
+	return []byte(sc.String()), nil
+}
+
+// UnmarshalText decodes a scope the way Atos does.
+func (sc *Scope) UnmarshalText(text []byte) error {
+	parsed, err := Atos(string(text))
+	if err != nil {
+		return err
+	}
+
+	*sc = parsed
+	return nil
+}
+
+// MarshalJSON encodes sc as a JSON string.
+func (sc Scope) MarshalJSON() ([]byte, error) {
+	return json.Marshal(sc.String())
+}
+
+// UnmarshalJSON decodes a JSON string the way Atos does.
+func (sc *Scope) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+
+	return sc.UnmarshalText([]byte(text))
+}
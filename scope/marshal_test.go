@@ -0,0 +1,58 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scope
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestScopeJSON(t *testing.T) {
+	sc, _ := Atos("public: private")
+
+	data, err := json.Marshal(sc)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if got, want := string(data), `"public:private"`; got != want {
+		t.Errorf("wanted %s got %s", want, got)
+	}
+
+	var decoded Scope
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if decoded != sc {
+		t.Errorf("wanted %q got %q", sc, decoded)
+	}
+
+	for _, bad := range []string{`""`, `"  "`, `3`} {
+		if err := json.Unmarshal([]byte(bad), &decoded); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+func TestScopeText(t *testing.T) {
+	var sc Scope
+	if err := sc.UnmarshalText([]byte("org::user")); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	text, _ := sc.MarshalText()
+	if got, want := string(text), "org:user"; got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/odeke-em/acl/acl"
+)
+
+const (
+	lockSuffix = ".lock"
+	tmpSuffix  = ".tmp"
+)
+
+type FileOptions struct {
+	// Sync makes Save fsync the written file and its
+	// directory, so that a saved Acl survives power loss.
+	Sync bool
+}
+
+// File stores an Acl in JSON form, name, UUID and TTL included, in a
+// single file. Saves write a temporary file and rename it over the
+// previous one, so a crash mid-write leaves either the old or the new
+// Acl, never a mix. While open, the File holds an advisory lock on a
+// sibling ".lock" file, keeping other processes from opening it. Once
+// closed, it fails every call with ErrClosed.
+type File struct {
+	mu   sync.Mutex
+	path string
+	opts FileOptions
+	lock *fileLock
+}
+
+// OpenFile opens the Acl stored at path, which need not exist yet.
+// It fails with ErrLocked if another File holds the lock.
+func OpenFile(path string, opts FileOptions) (*File, error) {
+	lock, err := acquireLock(path + lockSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	// Temporary files left behind by a crash are of no use.
+	if leftovers, _ := filepath.Glob(path + tmpSuffix + "*"); len(leftovers) > 0 {
+		for _, leftover := range leftovers {
+			os.Remove(leftover)
+		}
+	}
+
+	return &File{path: path, opts: opts, lock: lock}, nil
+}
+
+// Close releases the lock.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.lock == nil {
+		return nil
+	}
+
+	err := f.lock.release()
+	f.lock = nil
+	return err
+}
+
+func (f *File) Load() (*acl.Acl, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.lock == nil {
+		return nil, ErrClosed
+	}
+	return f.load()
+}
+
+func (f *File) Save(a *acl.Acl) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.lock == nil {
+		return ErrClosed
+	}
+	return f.save(a)
+}
+
+// Apply loads the Acl, applies m and saves the result.
+func (f *File) Apply(m acl.Mutation) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.lock == nil {
+		return ErrClosed
+	}
+
+	a, err := f.load()
+	if err != nil {
+		return err
+	}
+
+	if err := a.Mutate(m); err != nil {
+		return err
+	}
+
+	return f.save(a)
+}
+
+func (f *File) load() (*acl.Acl, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return acl.New("")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a := new(acl.Acl)
+	if err := a.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (f *File) save(a *acl.Acl) error {
+	data, err := a.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	return writeAtomically(f.path, data, f.opts.Sync)
+}
+
+// writeAtomically replaces the file at path with data
+// through a temporary file in the same directory.
+func writeAtomically(path string, data []byte, sync bool) (err error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := os.CreateTemp(dir, base+tmpSuffix+"*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if sync {
+		if err = tmp.Sync(); err != nil {
+			return err
+		}
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	if sync {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
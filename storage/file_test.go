@@ -0,0 +1,174 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/odeke-em/acl/acl"
+	"github.com/odeke-em/acl/permission"
+)
+
+func TestFileSaveLoadApply(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+
+	f, err := OpenFile(path, FileOptions{Sync: true})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+
+	empty, err := f.Load()
+	if err != nil || empty.String() != acl.Header {
+		t.Fatalf("expected an empty acl, got %q %v", empty, err)
+	}
+
+	a, _ := acl.Stoa("alice-read|write:bob-execute")
+	if err := f.Save(a); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	mutations := []acl.Mutation{
+		{Op: acl.OpRegisterUser, Scope: "carol"},
+		{Op: acl.OpInsert, Scope: "carol", Permissions: permission.Delete},
+		{Op: acl.OpRemove, Scope: "alice", Permissions: permission.Write},
+	}
+	for _, m := range mutations {
+		if err := f.Apply(m); err != nil {
+			t.Fatalf("apply %+v: %v", m, err)
+		}
+	}
+
+	if err := f.Apply(acl.Mutation{Op: acl.OpInsert, Scope: "nobody", Permissions: permission.Read}); err == nil {
+		t.Errorf("expected an error applying to an unregistered user")
+	}
+
+	loaded, err := f.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got, want := loaded.String(), acl.Header+"\nalice-read\nbob-execute\ncarol-delete"; got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+}
+
+func TestFileLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+
+	f, err := OpenFile(path, FileOptions{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	if _, err := OpenFile(path, FileOptions{}); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	g, err := OpenFile(path, FileOptions{})
+	if err != nil {
+		t.Fatalf("expected the lock to be free once closed, got %v", err)
+	}
+	g.Close()
+
+	if _, err := f.Load(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected Load to fail with ErrClosed, got %v", err)
+	}
+	if err := f.Save(new(acl.Acl)); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected Save to fail with ErrClosed, got %v", err)
+	}
+	if err := f.Apply(acl.Mutation{Op: acl.OpRegisterUser, Scope: "alice"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected Apply to fail with ErrClosed, got %v", err)
+	}
+}
+
+func TestFileKeepsMetadata(t *testing.T) {
+	fc := time.Unix(1700000000, 0)
+	path := filepath.Join(t.TempDir(), "acl.json")
+
+	f, err := OpenFile(path, FileOptions{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+
+	a, _ := acl.New("contractor")
+	a.SetName("docs")
+	a.SetTTL(time.Hour)
+	if err := f.Save(a); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	loaded, err := f.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if loaded.Name() != "docs" || loaded.UUID() != a.UUID() || loaded.TTL() != time.Hour {
+		t.Errorf("wanted %q %q %v, got %q %q %v", "docs", a.UUID(), time.Hour, loaded.Name(), loaded.UUID(), loaded.TTL())
+	}
+
+	// Inserts on the loaded Acl are still temporary.
+	loaded.SetClock(func() time.Time { return fc })
+	if _, err := loaded.Insert("contractor", permission.Read); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	loaded.SetClock(func() time.Time { return fc.Add(2 * time.Hour) })
+	if wasSet, _, _ := loaded.Check("contractor", permission.Read); len(wasSet) != 0 {
+		t.Errorf("expected the grant to have expired with the TTL")
+	}
+}
+
+func TestFileSurvivesInterruptedSave(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "acl.json")
+
+	f, err := OpenFile(path, FileOptions{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	a, _ := acl.Stoa("alice-read")
+	if err := f.Save(a); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	f.Close()
+
+	// A crash before the rename leaves a partially written temporary file.
+	torn := path + tmpSuffix + "123"
+	if err := os.WriteFile(torn, []byte("alice-re"), 0600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	f, err = OpenFile(path, FileOptions{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := os.Stat(torn); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the leftover temporary file to be removed, got %v", err)
+	}
+
+	loaded, err := f.Load()
+	if err != nil || loaded.String() != acl.Header+"\nalice-read" {
+		t.Errorf("expected the last saved acl, got %q %v", loaded, err)
+	}
+}
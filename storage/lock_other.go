@@ -0,0 +1,46 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly || illumos)
+
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+type fileLock struct {
+	path string
+}
+
+// acquireLock exclusively creates path. Unlike flock, the lock
+// outlives a crashed process and has to be removed by hand.
+func acquireLock(path string) (*fileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	return &fileLock{path: path}, nil
+}
+
+func (l *fileLock) release() error {
+	return os.Remove(l.path)
+}
@@ -0,0 +1,37 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storage persists acl.Acls.
+package storage
+
+import (
+	"errors"
+
+	"github.com/odeke-em/acl/acl"
+)
+
+var (
+	ErrLocked = errors.New("storage is locked by another process")
+	ErrClosed = errors.New("storage is closed")
+)
+
+// Backend loads and saves an Acl.
+type Backend interface {
+	// Load returns the stored Acl, which is empty if nothing was saved yet.
+	Load() (*acl.Acl, error)
+	// Save replaces the stored Acl with a.
+	Save(a *acl.Acl) error
+	// Apply makes m to the stored Acl.
+	Apply(m acl.Mutation) error
+}
@@ -0,0 +1,533 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/odeke-em/acl/acl"
+	"github.com/odeke-em/acl/permission"
+)
+
+var ErrCorruptLog = errors.New("corrupt write-ahead log")
+
+const (
+	snapshotPrefix = "snapshot-"
+	logPrefix      = "wal-"
+	logSuffix      = ".log"
+	walLockName    = "LOCK"
+
+	// recordHeaderSize covers the payload length and its CRC.
+	recordHeaderSize = 8
+
+	// maxRecordSize bounds the payload of a record, so that a corrupt
+	// length cannot make replay allocate without limit.
+	maxRecordSize = 1 << 24
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type WALOptions struct {
+	// Sync makes every append and snapshot fsync before returning.
+	Sync bool
+	// SnapshotEvery compacts the log into a new snapshot once
+	// it holds that many records. Zero disables compaction
+	// other than through Save and Snapshot.
+	SnapshotEvery int
+}
+
+// WAL stores an Acl in a directory as a snapshot in binary form, name,
+// UUID and TTL included, followed by a write-ahead log of the mutations
+// made since. Each record carries a CRC so that a record torn by a crash
+// is detected, and dropped, on the next Load. Once closed, the WAL fails
+// every call with ErrClosed.
+//
+// Snapshots and logs come in generations: compaction writes the
+// snapshot of generation n+1 before starting its empty log and only
+// then removes generation n, so a crash at any point leaves a
+// complete snapshot and the log that follows it.
+type WAL struct {
+	mu      sync.Mutex
+	dir     string
+	opts    WALOptions
+	lock    *fileLock
+	gen     uint64
+	log     *os.File
+	records int
+	acl     *acl.Acl
+}
+
+// OpenWAL opens the WAL in dir, creating dir if needed.
+// It fails with ErrLocked if another WAL holds dir.
+func OpenWAL(dir string, opts WALOptions) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	lock, err := acquireLock(filepath.Join(dir, walLockName))
+	if err != nil {
+		return nil, err
+	}
+
+	return &WAL{dir: dir, opts: opts, lock: lock}, nil
+}
+
+// Close closes the log and releases the lock.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var err error
+	if w.log != nil {
+		err = w.log.Close()
+		w.log = nil
+	}
+	if w.lock != nil {
+		if lErr := w.lock.release(); err == nil {
+			err = lErr
+		}
+		w.lock = nil
+	}
+
+	return err
+}
+
+// Load rebuilds the Acl from the latest snapshot and the log that
+// follows it, truncating a torn final record. The WAL keeps the
+// returned Acl and applies every later mutation to it, so it should
+// only be mutated through Apply.
+func (w *WAL) Load() (*acl.Acl, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.lock == nil {
+		return nil, ErrClosed
+	}
+	return w.load()
+}
+
+// Save makes a the new snapshot and starts an empty log after it.
+func (w *WAL) Save(a *acl.Acl) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.lock == nil {
+		return ErrClosed
+	}
+	return w.compact(a)
+}
+
+// Snapshot compacts the log into a new snapshot of the loaded Acl.
+func (w *WAL) Snapshot() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.lock == nil {
+		return ErrClosed
+	}
+	if _, err := w.ensureLoaded(); err != nil {
+		return err
+	}
+	return w.compact(w.acl)
+}
+
+// Apply makes m to the loaded Acl, loading it first if needed, and
+// appends it to the log. Mutations that fail are not logged.
+func (w *WAL) Apply(m acl.Mutation) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.lock == nil {
+		return ErrClosed
+	}
+
+	a, err := w.ensureLoaded()
+	if err != nil {
+		return err
+	}
+
+	if err := a.Mutate(m); err != nil {
+		return err
+	}
+
+	if err := w.append(m); err != nil {
+		// The log no longer matches the Acl in memory.
+		w.acl = nil
+		return err
+	}
+
+	if w.opts.SnapshotEvery > 0 && w.records >= w.opts.SnapshotEvery {
+		return w.compact(a)
+	}
+	return nil
+}
+
+func (w *WAL) ensureLoaded() (*acl.Acl, error) {
+	if w.acl != nil {
+		return w.acl, nil
+	}
+	return w.load()
+}
+
+func (w *WAL) load() (*acl.Acl, error) {
+	if w.log != nil {
+		w.log.Close()
+		w.log = nil
+	}
+
+	gen, err := w.latestGeneration()
+	if err != nil {
+		return nil, err
+	}
+	w.gen = gen
+
+	a, err := acl.New("")
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(w.snapshotPath(gen))
+	fresh := errors.Is(err, fs.ErrNotExist)
+	switch {
+	case err == nil:
+		if err := a.UnmarshalBinary(data); err != nil {
+			return nil, fmt.Errorf("snapshot %d: %w", gen, err)
+		}
+	case !fresh:
+		return nil, err
+	}
+
+	log, err := os.OpenFile(w.logPath(gen), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	records, end, err := replay(log, a)
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+
+	// Drop whatever a crash left after the last whole record.
+	if err := log.Truncate(end); err != nil {
+		log.Close()
+		return nil, err
+	}
+	if _, err := log.Seek(end, io.SeekStart); err != nil {
+		log.Close()
+		return nil, err
+	}
+
+	w.log, w.records, w.acl = log, records, a
+	w.removeGenerationsBefore(gen)
+
+	// Without a snapshot the UUID that New gave a would be lost.
+	if fresh {
+		if err := w.compact(a); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// replay applies every whole record in r to a, returning how many there
+// were and the offset just past the last one. Only the final record may
+// be torn; a damaged record followed by more data is ErrCorruptLog. A
+// length over maxRecordSize is only taken for a tear when nothing follows
+// the header, since no record that was written whole can have one.
+func replay(r io.Reader, a *acl.Acl) (records int, end int64, err error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, recordHeaderSize)
+
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			return records, end, nil
+		}
+
+		size := binary.LittleEndian.Uint32(header[:4])
+		sum := binary.LittleEndian.Uint32(header[4:])
+
+		if size > maxRecordSize {
+			if _, err := br.Peek(1); err == io.EOF {
+				return records, end, nil
+			}
+			return records, end, fmt.Errorf("%w: record of %d bytes at offset %d", ErrCorruptLog, size, end)
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return records, end, nil
+		}
+
+		if crc32.Checksum(payload, castagnoli) != sum {
+			if _, err := br.Peek(1); err == io.EOF {
+				return records, end, nil
+			}
+			return records, end, fmt.Errorf("%w: bad checksum at offset %d", ErrCorruptLog, end)
+		}
+
+		m, dErr := decodeMutation(payload)
+		if dErr != nil {
+			return records, end, fmt.Errorf("%w: offset %d: %v", ErrCorruptLog, end, dErr)
+		}
+		if mErr := a.Mutate(m); mErr != nil {
+			return records, end, fmt.Errorf("replaying offset %d: %w", end, mErr)
+		}
+
+		records++
+		end += int64(recordHeaderSize) + int64(size)
+	}
+}
+
+func (w *WAL) append(m acl.Mutation) error {
+	payload := encodeMutation(m)
+	if len(payload) > maxRecordSize {
+		return fmt.Errorf("mutation of %d bytes exceeds the %d byte record limit", len(payload), maxRecordSize)
+	}
+
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:], crc32.Checksum(payload, castagnoli))
+	record = append(record, payload...)
+
+	if _, err := w.log.Write(record); err != nil {
+		return err
+	}
+	if w.opts.Sync {
+		if err := w.log.Sync(); err != nil {
+			return err
+		}
+	}
+
+	w.records++
+	return nil
+}
+
+// compact writes a as the snapshot of the next generation, switches
+// to that generation's empty log and removes older generations.
+func (w *WAL) compact(a *acl.Acl) error {
+	if w.acl == nil && w.log == nil {
+		// Find out which generation comes next.
+		gen, err := w.latestGeneration()
+		if err != nil {
+			return err
+		}
+		w.gen = gen
+	}
+
+	data, err := a.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	next := w.gen + 1
+	if err := writeAtomically(w.snapshotPath(next), data, w.opts.Sync); err != nil {
+		return err
+	}
+
+	log, err := os.OpenFile(w.logPath(next), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if w.opts.Sync {
+		if err := syncDir(w.dir); err != nil {
+			log.Close()
+			return err
+		}
+	}
+
+	if w.log != nil {
+		w.log.Close()
+	}
+	w.gen, w.log, w.records, w.acl = next, log, 0, a
+	w.removeGenerationsBefore(next)
+	return nil
+}
+
+// latestGeneration returns the generation of the newest snapshot, or 0.
+func (w *WAL) latestGeneration() (uint64, error) {
+	gens, err := w.generations(snapshotPrefix, "")
+	if err != nil || len(gens) == 0 {
+		return 0, err
+	}
+	return gens[len(gens)-1], nil
+}
+
+func (w *WAL) removeGenerationsBefore(gen uint64) {
+	for _, kind := range [][2]string{{snapshotPrefix, ""}, {logPrefix, logSuffix}} {
+		gens, _ := w.generations(kind[0], kind[1])
+		for _, g := range gens {
+			if g < gen {
+				os.Remove(filepath.Join(w.dir, generationName(kind[0], g, kind[1])))
+			}
+		}
+	}
+}
+
+// generations returns the sorted generations of the files named prefix<gen>suffix.
+func (w *WAL) generations(prefix, suffix string) ([]uint64, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	gens := []uint64{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+
+		gen, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		gens = append(gens, gen)
+	}
+	sort.Slice(gens, func(i, j int) bool { return gens[i] < gens[j] })
+
+	return gens, nil
+}
+
+func generationName(prefix string, gen uint64, suffix string) string {
+	return fmt.Sprintf("%s%020d%s", prefix, gen, suffix)
+}
+
+func (w *WAL) snapshotPath(gen uint64) string {
+	return filepath.Join(w.dir, generationName(snapshotPrefix, gen, ""))
+}
+
+func (w *WAL) logPath(gen uint64) string {
+	return filepath.Join(w.dir, generationName(logPrefix, gen, logSuffix))
+}
+
+// encodeMutation lays m out as its op followed by its scope, subject,
+// permissions, expiry in Unix seconds, included roles and TTL, with
+// strings length-prefixed and integers varint encoded.
+func encodeMutation(m acl.Mutation) []byte {
+	var buf bytes.Buffer
+	scratch := make([]byte, binary.MaxVarintLen64)
+
+	putUvarint := func(v uint64) {
+		buf.Write(scratch[:binary.PutUvarint(scratch, v)])
+	}
+	putString := func(s string) {
+		putUvarint(uint64(len(s)))
+		buf.WriteString(s)
+	}
+
+	putUvarint(uint64(m.Op))
+	putString(m.Scope)
+	putString(m.Subject)
+	putUvarint(uint64(m.Permissions))
+
+	var expiry int64
+	if !m.Expiry.IsZero() {
+		expiry = m.Expiry.Unix()
+	}
+	buf.Write(scratch[:binary.PutVarint(scratch, expiry)])
+
+	putUvarint(uint64(len(m.Includes)))
+	for _, role := range m.Includes {
+		putString(role)
+	}
+
+	buf.Write(scratch[:binary.PutVarint(scratch, int64(m.TTL))])
+
+	return buf.Bytes()
+}
+
+func decodeMutation(payload []byte) (m acl.Mutation, err error) {
+	r := bytes.NewReader(payload)
+
+	getString := func() (string, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return "", err
+		}
+		if n > uint64(r.Len()) {
+			return "", io.ErrUnexpectedEOF
+		}
+		s := make([]byte, n)
+		_, err = io.ReadFull(r, s)
+		return string(s), err
+	}
+
+	op, err := binary.ReadUvarint(r)
+	if err != nil {
+		return
+	}
+	m.Op = acl.Op(op)
+
+	if m.Scope, err = getString(); err != nil {
+		return
+	}
+	if m.Subject, err = getString(); err != nil {
+		return
+	}
+
+	perms, err := binary.ReadUvarint(r)
+	if err != nil {
+		return
+	}
+	m.Permissions = permission.Permission(perms)
+
+	expiry, err := binary.ReadVarint(r)
+	if err != nil {
+		return
+	}
+	if expiry != 0 {
+		m.Expiry = time.Unix(expiry, 0)
+	}
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return
+	}
+	if count > uint64(r.Len()) {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+	for i := uint64(0); i < count; i++ {
+		role, sErr := getString()
+		if sErr != nil {
+			err = sErr
+			return
+		}
+		m.Includes = append(m.Includes, role)
+	}
+
+	ttl, err := binary.ReadVarint(r)
+	if err != nil {
+		return
+	}
+	m.TTL = time.Duration(ttl)
+
+	return
+}
@@ -0,0 +1,361 @@
+// Copyright 2015 Emmanuel Odeke. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/odeke-em/acl/acl"
+	"github.com/odeke-em/acl/permission"
+)
+
+func openWAL(t *testing.T, dir string, opts WALOptions) *WAL {
+	t.Helper()
+
+	w, err := OpenWAL(dir, opts)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return w
+}
+
+func applyAll(t *testing.T, w *WAL, mutations ...acl.Mutation) {
+	t.Helper()
+
+	for _, m := range mutations {
+		if err := w.Apply(m); err != nil {
+			t.Fatalf("apply %+v: %v", m, err)
+		}
+	}
+}
+
+var walMutations = []acl.Mutation{
+	{Op: acl.OpRegisterUser, Scope: "alice"},
+	{Op: acl.OpInsert, Scope: "alice", Permissions: permission.Write},
+	{Op: acl.OpRegisterUser, Scope: "bob"},
+	{Op: acl.OpInsert, Scope: "bob", Permissions: permission.Execute},
+	{Op: acl.OpRemove, Scope: "alice", Permissions: permission.Write},
+	{Op: acl.OpInsert, Scope: "alice", Permissions: permission.Read},
+}
+
+const walWant = acl.Header + "\nalice-read\nbob-execute"
+
+func TestWALReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	w := openWAL(t, dir, WALOptions{Sync: true})
+	applyAll(t, w, walMutations...)
+
+	if err := w.Apply(acl.Mutation{Op: acl.OpInsert, Scope: "nobody", Permissions: permission.Read}); err == nil {
+		t.Errorf("expected an error applying to an unregistered user")
+	}
+	w.Close()
+
+	w = openWAL(t, dir, WALOptions{})
+	defer w.Close()
+
+	a, err := w.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := a.String(); got != walWant {
+		t.Errorf("wanted %q got %q", walWant, got)
+	}
+}
+
+func TestWALTornRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	w := openWAL(t, dir, WALOptions{})
+	applyAll(t, w, walMutations...)
+	applyAll(t, w, acl.Mutation{Op: acl.OpInsert, Scope: "bob", Permissions: permission.Delete})
+	log := w.logPath(w.gen)
+	w.Close()
+
+	info, err := os.Stat(log)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	// Simulate a crash partway through writing the last record.
+	if err := os.Truncate(log, info.Size()-2); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	w = openWAL(t, dir, WALOptions{})
+	a, err := w.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := a.String(); got != walWant {
+		t.Errorf("wanted %q got %q", walWant, got)
+	}
+
+	// The torn bytes are gone, so later records replay cleanly.
+	applyAll(t, w, acl.Mutation{Op: acl.OpInsert, Scope: "bob", Permissions: permission.List})
+	w.Close()
+
+	w = openWAL(t, dir, WALOptions{})
+	defer w.Close()
+
+	if a, err = w.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got, want := a.String(), acl.Header+"\nalice-read\nbob-list|execute"; got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+}
+
+func TestWALCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	w := openWAL(t, dir, WALOptions{})
+	applyAll(t, w, walMutations...)
+	log := w.logPath(w.gen)
+	w.Close()
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	data[recordHeaderSize] ^= 0xff
+	if err := os.WriteFile(log, data, 0600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	w = openWAL(t, dir, WALOptions{})
+	defer w.Close()
+
+	if _, err := w.Load(); !errors.Is(err, ErrCorruptLog) {
+		t.Errorf("expected ErrCorruptLog, got %v", err)
+	}
+}
+
+func TestWALOversizedLength(t *testing.T) {
+	oversized := make([]byte, recordHeaderSize)
+	binary.LittleEndian.PutUint32(oversized, 0xffffffff)
+
+	cases := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+		want    error
+	}{
+		{
+			name:    "at the tail",
+			corrupt: func(data []byte) []byte { return append(data, oversized...) },
+		},
+		{
+			name: "in the middle",
+			corrupt: func(data []byte) []byte {
+				copy(data, oversized[:4])
+				return data
+			},
+			want: ErrCorruptLog,
+		},
+	}
+
+	for _, tc := range cases {
+		dir := t.TempDir()
+
+		w := openWAL(t, dir, WALOptions{})
+		applyAll(t, w, walMutations...)
+		log := w.logPath(w.gen)
+		w.Close()
+
+		data, err := os.ReadFile(log)
+		if err != nil {
+			t.Fatalf("%s: read: %v", tc.name, err)
+		}
+		if err := os.WriteFile(log, tc.corrupt(data), 0600); err != nil {
+			t.Fatalf("%s: write: %v", tc.name, err)
+		}
+
+		w = openWAL(t, dir, WALOptions{})
+		a, err := w.Load()
+		w.Close()
+
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: wanted %v got %v", tc.name, tc.want, err)
+		}
+		if err == nil && a.String() != walWant {
+			t.Errorf("%s: wanted %q got %q", tc.name, walWant, a)
+		}
+	}
+}
+
+func TestWALSnapshotEvery(t *testing.T) {
+	dir := t.TempDir()
+
+	w := openWAL(t, dir, WALOptions{SnapshotEvery: 4})
+	applyAll(t, w, walMutations...)
+
+	// Generation 1 is the snapshot of the empty Acl.
+	if w.gen != 2 || w.records != 2 {
+		t.Errorf("expected generation 2 with 2 records, got %d with %d", w.gen, w.records)
+	}
+	w.Close()
+
+	snapshots, _ := filepath.Glob(filepath.Join(dir, snapshotPrefix+"*"))
+	logs, _ := filepath.Glob(filepath.Join(dir, logPrefix+"*"))
+	if len(snapshots) != 1 || len(logs) != 1 {
+		t.Errorf("expected one snapshot and one log, got %v %v", snapshots, logs)
+	}
+
+	w = openWAL(t, dir, WALOptions{})
+	defer w.Close()
+
+	a, err := w.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := a.String(); got != walWant {
+		t.Errorf("wanted %q got %q", walWant, got)
+	}
+}
+
+func TestWALCrashDuringCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	w := openWAL(t, dir, WALOptions{})
+	applyAll(t, w, walMutations...)
+	gen := w.gen
+	w.Close()
+
+	// A crash after the next snapshot was written but before its log
+	// was started must not replay the old log on top of it.
+	want, _ := acl.Stoa(walWant)
+	data, _ := want.MarshalBinary()
+	if err := writeAtomically(filepath.Join(dir, generationName(snapshotPrefix, gen+1, "")), data, false); err != nil {
+		t.Fatalf("write snapshot: %v", err)
+	}
+
+	w = openWAL(t, dir, WALOptions{})
+	defer w.Close()
+
+	a, err := w.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := a.String(); got != walWant {
+		t.Errorf("wanted %q got %q", walWant, got)
+	}
+	if _, err := os.Stat(w.logPath(gen)); !os.IsNotExist(err) {
+		t.Errorf("expected the old log to be removed, got %v", err)
+	}
+}
+
+func TestWALSave(t *testing.T) {
+	dir := t.TempDir()
+
+	w := openWAL(t, dir, WALOptions{})
+	a, _ := acl.Stoa("carol-delete")
+	if err := w.Save(a); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	applyAll(t, w, acl.Mutation{Op: acl.OpDeny, Scope: "carol", Permissions: permission.Write})
+	w.Close()
+
+	w = openWAL(t, dir, WALOptions{})
+	defer w.Close()
+
+	loaded, err := w.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got, want := loaded.String(), acl.Header+"\ncarol-delete-!write"; got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+}
+
+func TestWALKeepsMetadata(t *testing.T) {
+	dir := t.TempDir()
+
+	w := openWAL(t, dir, WALOptions{})
+	a, err := w.Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	uuid := a.UUID()
+	applyAll(t, w, acl.Mutation{Op: acl.OpSetTTL, TTL: time.Hour})
+	w.Close()
+
+	check := func(stage string) {
+		w = openWAL(t, dir, WALOptions{})
+		loaded, err := w.Load()
+		if err != nil {
+			t.Fatalf("%s: load: %v", stage, err)
+		}
+		if loaded.UUID() != uuid || uuid == "" || loaded.TTL() != time.Hour {
+			t.Errorf("%s: wanted %q and %v, got %q and %v", stage, uuid, time.Hour, loaded.UUID(), loaded.TTL())
+		}
+	}
+
+	check("replayed")
+	if err := w.Snapshot(); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	w.Close()
+	check("snapshotted")
+
+	w.Close()
+	if _, err := w.Load(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected Load to fail with ErrClosed, got %v", err)
+	}
+	if err := w.Apply(acl.Mutation{Op: acl.OpRegisterUser, Scope: "alice"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected Apply to fail with ErrClosed, got %v", err)
+	}
+}
+
+func TestWALLock(t *testing.T) {
+	dir := t.TempDir()
+
+	w := openWAL(t, dir, WALOptions{})
+	if _, err := OpenWAL(dir, WALOptions{}); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+	w.Close()
+
+	w = openWAL(t, dir, WALOptions{})
+	w.Close()
+}
+
+func TestMutationEncoding(t *testing.T) {
+	mutations := []acl.Mutation{
+		{Op: acl.OpRegisterUser, Scope: "alice"},
+		{Op: acl.OpInsert, Scope: "alice", Permissions: permission.Read | permission.Write, Expiry: time.Unix(1700000000, 0)},
+		{Op: acl.OpAddMember, Scope: "staff", Subject: "alice"},
+		{Op: acl.OpDefineRole, Subject: "editor", Permissions: permission.Write, Includes: []string{"viewer", "commenter"}},
+		{Op: acl.OpSetTTL, TTL: time.Hour},
+	}
+
+	for _, m := range mutations {
+		got, err := decodeMutation(encodeMutation(m))
+		if err != nil {
+			t.Fatalf("decode %+v: %v", m, err)
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("wanted %+v got %+v", m, got)
+		}
+	}
+
+	if _, err := decodeMutation(encodeMutation(mutations[3])[:5]); err == nil {
+		t.Errorf("expected an error decoding a truncated payload")
+	}
+}